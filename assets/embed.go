@@ -0,0 +1,11 @@
+// Package assets holds the bundled Robohash image sets and backgrounds,
+// compiled into the binary so it does not depend on the working directory.
+package assets
+
+import "embed"
+
+// FS contains every set directory and the backgrounds tree. Custom sets
+// dropped next to the bundled ones are embedded as well.
+//
+//go:embed *
+var FS embed.FS
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// lastModified is the Last-Modified time of every avatar, the newest asset
+// file. It is zero for assets without modification times, like the embedded
+// ones: a build or deploy time would differ between replicas serving the
+// same assets, so those responses rely on the fingerprinted ETag alone.
+func (s *server) lastModified() time.Time {
+	t := s.gen.AssetsModTime()
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+// avatarETag derives the strong validator of the avatar with the render key,
+// which carries the asset fingerprint. It is known before rendering, so
+// conditional requests are answered without any image work.
+func avatarETag(key string, format robohash.Format) string {
+	hash := sha256.Sum256([]byte(robohash.AlgorithmVersion + "|" + key))
+	return `"` + string(format) + "-" + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// setValidators marks a complete response as cacheable for a year, which
+// its validators make safe across asset changes.
+func setValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is
+// none and modTime is known, against the validators of a response, as RFC 9110 section 13.2.2
+// orders them for GET and HEAD.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modTime.After(t)
+	}
+	return false
+}
+
+// etagMatch reports whether an If-None-Match list names etag, using the
+// weak comparison.
+func etagMatch(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
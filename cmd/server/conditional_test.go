@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestNotModified(t *testing.T) {
+	etag := `"png-0123"`
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		method string
+		header string
+		value  string
+		want   bool
+	}{
+		{http.MethodGet, "", "", false},
+		{http.MethodGet, "If-None-Match", `"png-0123"`, true},
+		{http.MethodGet, "If-None-Match", `W/"png-0123"`, true},
+		{http.MethodGet, "If-None-Match", `"webp-0123", "png-0123"`, true},
+		{http.MethodGet, "If-None-Match", `*`, true},
+		{http.MethodGet, "If-None-Match", `"png-4567"`, false},
+		{http.MethodHead, "If-None-Match", `"png-0123"`, true},
+		{http.MethodPost, "If-None-Match", `"png-0123"`, false},
+		{http.MethodGet, "If-Modified-Since", "Wed, 01 May 2024 12:00:00 GMT", true},
+		{http.MethodGet, "If-Modified-Since", "Thu, 02 May 2024 00:00:00 GMT", true},
+		{http.MethodGet, "If-Modified-Since", "Tue, 30 Apr 2024 00:00:00 GMT", false},
+		{http.MethodGet, "If-Modified-Since", "yesterday", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/alice", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := notModified(r, etag, modTime); got != tt.want {
+			t.Errorf("notModified(%s %s: %s) = %v, want %v", tt.method, tt.header, tt.value, got, tt.want)
+		}
+	}
+
+	// Without a modification time If-Modified-Since cannot match.
+	r := httptest.NewRequest(http.MethodGet, "/alice", nil)
+	r.Header.Set("If-Modified-Since", "Thu, 02 May 2024 00:00:00 GMT")
+	if notModified(r, etag, time.Time{}) {
+		t.Error("notModified() matched If-Modified-Since without a modification time")
+	}
+
+	// A stale If-None-Match wins over a current If-Modified-Since.
+	r = httptest.NewRequest(http.MethodGet, "/alice", nil)
+	r.Header.Set("If-None-Match", `"png-4567"`)
+	r.Header.Set("If-Modified-Since", "Thu, 02 May 2024 00:00:00 GMT")
+	if notModified(r, etag, modTime) {
+		t.Error("notModified() used If-Modified-Since despite If-None-Match")
+	}
+}
+
+func TestAvatarETag(t *testing.T) {
+	req := robohash.Request{Text: "alice", Set: robohash.Set1}
+	opts := robohash.DefaultEncodeOptions(robohash.FormatPNG)
+	tag := avatarETag(renderKey("fp", req, nil, robohash.FormatPNG, opts), robohash.FormatPNG)
+
+	if !strings.HasPrefix(tag, `"png-`) || !strings.HasSuffix(tag, `"`) {
+		t.Errorf("avatarETag() = %s, want a quoted tag prefixed with the format", tag)
+	}
+	if again := avatarETag(renderKey("fp", req, nil, robohash.FormatPNG, opts), robohash.FormatPNG); again != tag {
+		t.Errorf("avatarETag() = %s, then %s", tag, again)
+	}
+	if other := avatarETag(renderKey("changed", req, nil, robohash.FormatPNG, opts), robohash.FormatPNG); other == tag {
+		t.Error("avatarETag() ignores the asset fingerprint")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// diskCache stores encoded avatars as files in a directory, named by the
+// SHA-256 of their render key under a two character fan-out directory. The
+// total file size is bounded; the least recently used files are removed
+// first. Files found at startup are adopted in modification time order, so
+// the cache survives restarts.
+type diskCache struct {
+	dir string
+
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+// newDiskCache opens the cache in dir, creating the directory if needed.
+func newDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil || len(d.Name()) != sha256.Size*2 {
+			// Leftover temporary files and anything else not ours.
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{name, info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		c.items[f.name] = c.ll.PushFront(&diskEntry{name: f.name, size: f.size})
+		c.bytes += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// fileName returns the cache file of key, relative to the cache directory.
+func (c *diskCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(name[:2], name)
+}
+
+func (c *diskCache) get(key string) ([]byte, bool) {
+	name := c.fileName(key)
+
+	c.mu.Lock()
+	el, ok := c.items[name]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.mu.Unlock()
+
+	body, err := os.ReadFile(filepath.Join(c.dir, name))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Printf("Output cache: %v", err)
+		if el, ok := c.items[name]; ok {
+			c.remove(el)
+		}
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+
+	// Keep the order across restarts.
+	now := time.Now()
+	os.Chtimes(filepath.Join(c.dir, name), now, now)
+	return body, true
+}
+
+func (c *diskCache) add(key string, body []byte) {
+	size := int64(len(body))
+	if size > c.maxBytes {
+		return
+	}
+	name := c.fileName(key)
+	path := filepath.Join(c.dir, name)
+
+	// Write to a temporary file first, so readers never see half a file.
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Printf("Output cache: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		log.Printf("Output cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Output cache: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[name]; ok {
+		c.bytes -= el.Value.(*diskEntry).size
+		c.ll.Remove(el)
+	}
+	c.items[name] = c.ll.PushFront(&diskEntry{name: name, size: size})
+	c.bytes += size
+	c.evict()
+}
+
+func (c *diskCache) size(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[c.fileName(key)]; ok {
+		return el.Value.(*diskEntry).size, true
+	}
+	return 0, false
+}
+
+// evict removes the least recently used files until the cache fits.
+func (c *diskCache) evict() {
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// remove drops an entry and its file.
+func (c *diskCache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*diskEntry)
+	delete(c.items, entry.name)
+	c.bytes -= entry.size
+	if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Output cache: %v", err)
+	}
+}
+
+func (c *diskCache) stats() robohash.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return robohash.CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("a", []byte("aaaa"))
+	c.add("b", []byte("bbbb"))
+	c.get("a")
+	c.add("c", []byte("cccc"))
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry b was not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, c.fileName("b"))); !os.IsNotExist(err) {
+		t.Errorf("file of evicted entry b: %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if body, ok := c.get(key); !ok || !bytes.Equal(body, bytes.Repeat([]byte(key), 4)) {
+			t.Errorf("get(%s) = %q, %v", key, body, ok)
+		}
+	}
+
+	stats := c.stats()
+	if stats.Entries != 2 || stats.Bytes != 8 || stats.Evictions != 1 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("stats() = %+v", stats)
+	}
+}
+
+func TestDiskCacheSize(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("a", []byte("aaaa"))
+
+	// The size comes from the index, not the file.
+	if err := os.Remove(filepath.Join(dir, c.fileName("a"))); err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := c.size("a"); !ok || size != 4 {
+		t.Errorf("size(a) = %d, %v", size, ok)
+	}
+	if _, ok := c.size("missing"); ok {
+		t.Error("size(missing) found an entry")
+	}
+	if stats := c.stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("size counted as a lookup: %+v", stats)
+	}
+}
+
+func TestDiskCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("a", []byte("avatar"))
+	// Leftovers of an interrupted write are not entries.
+	os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0o644)
+
+	c, err = newDiskCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, ok := c.get("a"); !ok || string(body) != "avatar" {
+		t.Errorf("get(a) after reopening = %q, %v", body, ok)
+	}
+	if stats := c.stats(); stats.Entries != 1 || stats.Bytes != 6 {
+		t.Errorf("stats() = %+v", stats)
+	}
+
+	// A smaller bound drops what no longer fits.
+	c, err = newDiskCache(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("stats() after shrinking = %+v", stats)
+	}
+}
+
+func TestDiskCacheMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newDiskCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("a", []byte("avatar"))
+	os.Remove(filepath.Join(dir, c.fileName("a")))
+
+	if _, ok := c.get("a"); ok {
+		t.Error("get() hit on a removed file")
+	}
+	if stats := c.stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("stats() = %+v", stats)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// encodeParams are encoder settings asked for by a profile or the query.
+// Nil fields keep the value below them.
+type encodeParams struct {
+	Quality      *int  `json:"quality,omitempty"`
+	Lossless     *bool `json:"lossless,omitempty"`
+	NearLossless *bool `json:"nearLossless,omitempty"`
+	Effort       *int  `json:"effort,omitempty"`
+	Palette      *bool `json:"palette,omitempty"`
+	Bitdepth     *int  `json:"bitdepth,omitempty"`
+}
+
+// defaultProfiles are available without a profiles file.
+var defaultProfiles = map[string]encodeParams{
+	// Small lossy images for lists and mobile clients.
+	"thumb": {Quality: intPtr(60), Lossless: boolPtr(false), Effort: intPtr(2)},
+	// Lossless images at the best compression for print exports.
+	"print": {Lossless: boolPtr(true), Effort: intPtr(robohash.MaxEffort)},
+}
+
+func intPtr(n int) *int    { return &n }
+func boolPtr(b bool) *bool { return &b }
+
+// loadProfiles reads the named encoder profiles of a JSON file such as
+// {"thumb": {"quality": 60, "effort": 2}}, on top of defaultProfiles.
+func loadProfiles(name string) (map[string]encodeParams, error) {
+	profiles := make(map[string]encodeParams, len(defaultProfiles))
+	for profile, params := range defaultProfiles {
+		profiles[profile] = params
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]encodeParams
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	for profile, params := range custom {
+		if err := params.validate(robohash.MaxEffort); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile, err)
+		}
+		profiles[profile] = params
+	}
+	return profiles, nil
+}
+
+// parseEncodeParams reads the quality, lossless, nearlossless, effort,
+// palette and bitdepth query parameters.
+func parseEncodeParams(query url.Values) (encodeParams, error) {
+	var p encodeParams
+	for _, name := range []string{"quality", "effort", "bitdepth"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return encodeParams{}, fmt.Errorf("%w: %s=%q is not a number", robohash.ErrInvalidEncodeOptions, name, value)
+		}
+		switch name {
+		case "quality":
+			p.Quality = &n
+		case "effort":
+			p.Effort = &n
+		case "bitdepth":
+			p.Bitdepth = &n
+		}
+	}
+	if value := query.Get("lossless"); value != "" {
+		p.Lossless = boolPtr(isTrue(value))
+	}
+	if value := query.Get("nearlossless"); value != "" {
+		p.NearLossless = boolPtr(isTrue(value))
+	}
+	if value := query.Get("palette"); value != "" {
+		p.Palette = boolPtr(isTrue(value))
+	}
+	return p, nil
+}
+
+// validate checks the format independent bounds.
+func (p encodeParams) validate(effortLimit int) error {
+	if p.Quality != nil && (*p.Quality < 1 || *p.Quality > 100) {
+		return fmt.Errorf("%w: quality %d outside 1-100", robohash.ErrInvalidEncodeOptions, *p.Quality)
+	}
+	if p.Effort != nil && (*p.Effort < 0 || *p.Effort > effortLimit) {
+		return fmt.Errorf("%w: effort %d outside 0-%d", robohash.ErrInvalidEncodeOptions, *p.Effort, effortLimit)
+	}
+	return nil
+}
+
+// apply overrides the fields of o that p sets.
+func (p encodeParams) apply(o robohash.EncodeOptions) robohash.EncodeOptions {
+	if p.Quality != nil {
+		o.Quality = *p.Quality
+	}
+	if p.Lossless != nil {
+		o.Lossless = *p.Lossless
+	}
+	if p.NearLossless != nil {
+		o.NearLossless = *p.NearLossless
+	}
+	if p.Effort != nil {
+		o.Effort = *p.Effort
+	}
+	if p.Palette != nil {
+		o.Palette = *p.Palette
+	}
+	if p.Bitdepth != nil {
+		o.Bitdepth = *p.Bitdepth
+	}
+	return o
+}
+
+// encodeOptions resolves the settings for format: the format defaults,
+// then the profile, then the query parameters, checked against the server
+// bounds.
+func (s *server) encodeOptions(format robohash.Format, query url.Values) (robohash.EncodeOptions, error) {
+	opts := robohash.DefaultEncodeOptions(format)
+
+	if name := query.Get("profile"); name != "" {
+		profile, ok := s.profiles[name]
+		if !ok {
+			return robohash.EncodeOptions{}, fmt.Errorf("%w: unknown profile %s", robohash.ErrInvalidEncodeOptions, name)
+		}
+		opts = profile.apply(opts)
+	}
+
+	params, err := parseEncodeParams(query)
+	if err != nil {
+		return robohash.EncodeOptions{}, err
+	}
+	if err := params.validate(s.maxEffort); err != nil {
+		return robohash.EncodeOptions{}, err
+	}
+	opts = params.apply(opts)
+
+	if opts.Effort > s.maxEffort {
+		// A profile may ask for more than the server allows.
+		opts.Effort = s.maxEffort
+	}
+	if err := opts.Validate(format); err != nil {
+		return robohash.EncodeOptions{}, err
+	}
+	return opts, nil
+}
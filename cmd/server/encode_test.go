@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestEncodeOptions(t *testing.T) {
+	s := &server{profiles: defaultProfiles, maxEffort: 7}
+
+	tests := []struct {
+		format robohash.Format
+		query  string
+		want   robohash.EncodeOptions
+	}{
+		{robohash.FormatPNG, "", robohash.EncodeOptions{Quality: 85, Effort: 6}},
+		{robohash.FormatWebP, "", robohash.EncodeOptions{Quality: 85, Lossless: true, Effort: 4}},
+		{robohash.FormatWebP, "quality=50&lossless=0&effort=1", robohash.EncodeOptions{Quality: 50, Effort: 1}},
+		{robohash.FormatWebP, "nearlossless=1&quality=60", robohash.EncodeOptions{Quality: 60, Lossless: true, NearLossless: true, Effort: 4}},
+		// AVIF defaults to effort 8, above this server's limit.
+		{robohash.FormatAVIF, "bitdepth=10", robohash.EncodeOptions{Quality: 85, Effort: 7, Bitdepth: 10}},
+		{robohash.FormatPNG, "palette=1&bitdepth=4", robohash.EncodeOptions{Quality: 85, Effort: 6, Palette: true, Bitdepth: 4}},
+		{robohash.FormatJPEG, "profile=thumb", robohash.EncodeOptions{Quality: 60, Effort: 2}},
+		{robohash.FormatWebP, "profile=thumb&quality=70", robohash.EncodeOptions{Quality: 70, Effort: 2}},
+		// The print profile asks for more effort than the server allows.
+		{robohash.FormatPNG, "profile=print", robohash.EncodeOptions{Quality: 85, Lossless: true, Effort: 7}},
+	}
+
+	for _, tc := range tests {
+		query, _ := url.ParseQuery(tc.query)
+		got, err := s.encodeOptions(tc.format, query)
+		if err != nil {
+			t.Errorf("encodeOptions(%s, %q) failed: %v", tc.format, tc.query, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("encodeOptions(%s, %q) = %+v, want %+v", tc.format, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeOptionsInvalid(t *testing.T) {
+	s := &server{profiles: defaultProfiles, maxEffort: 7}
+
+	tests := []struct {
+		format robohash.Format
+		query  string
+	}{
+		{robohash.FormatPNG, "quality=0"},
+		{robohash.FormatPNG, "quality=101"},
+		{robohash.FormatPNG, "quality=high"},
+		{robohash.FormatPNG, "effort=-1"},
+		{robohash.FormatAVIF, "effort=8"},
+		{robohash.FormatPNG, "bitdepth=4"},
+		{robohash.FormatPNG, "palette=1&bitdepth=16"},
+		{robohash.FormatAVIF, "bitdepth=16"},
+		{robohash.FormatJPEG, "bitdepth=8"},
+		{robohash.FormatPNG, "profile=poster"},
+	}
+
+	for _, tc := range tests {
+		query, _ := url.ParseQuery(tc.query)
+		if _, err := s.encodeOptions(tc.format, query); !errors.Is(err, robohash.ErrInvalidEncodeOptions) {
+			t.Errorf("encodeOptions(%s, %q) error = %v, want robohash.ErrInvalidEncodeOptions", tc.format, tc.query, err)
+		}
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(name, []byte(`{"mobile": {"quality": 40, "lossless": false}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := loadProfiles(name)
+	if err != nil {
+		t.Fatalf("loadProfiles() failed: %v", err)
+	}
+	if _, ok := profiles["thumb"]; !ok {
+		t.Error("loadProfiles() dropped the default thumb profile")
+	}
+	mobile, ok := profiles["mobile"]
+	if !ok || mobile.Quality == nil || *mobile.Quality != 40 || mobile.Effort != nil {
+		t.Errorf("loadProfiles() mobile = %+v", mobile)
+	}
+
+	if err := os.WriteFile(name, []byte(`{"bad": {"quality": 0}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadProfiles(name); err == nil {
+		t.Error("loadProfiles() accepted quality 0")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// errorResponse is the body of every failed request.
+type errorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// errorStatus maps an error to its HTTP status. Client mistakes are told
+// apart from timeouts and real failures, which alone are server errors.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, robohash.ErrUnknownSet), errors.Is(err, robohash.ErrUnknownBackground):
+		return http.StatusNotFound
+	case errors.Is(err, robohash.ErrInvalidSize), errors.Is(err, robohash.ErrUnknownFormat),
+		errors.Is(err, robohash.ErrInvalidEncodeOptions), errors.Is(err, robohash.ErrInvalidAnimation):
+		return http.StatusBadRequest
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError reports err as a JSON error body.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	message := err.Error()
+	switch status {
+	case http.StatusGatewayTimeout:
+		message = "image rendering timed out"
+	case http.StatusServiceUnavailable:
+		message = "image rendering canceled"
+	case http.StatusInternalServerError:
+		log.Printf("Error generating image: %v", err)
+	}
+
+	body, _ := json.Marshal(errorResponse{Error: message, Status: status})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: set9", robohash.ErrUnknownSet), http.StatusNotFound},
+		{fmt.Errorf("%w: bg9", robohash.ErrUnknownBackground), http.StatusNotFound},
+		{fmt.Errorf("%w \"abc\"", robohash.ErrInvalidSize), http.StatusBadRequest},
+		{fmt.Errorf("%w: bmp", robohash.ErrUnknownFormat), http.StatusBadRequest},
+		{fmt.Errorf("%w: quality 0 outside 1-100", robohash.ErrInvalidEncodeOptions), http.StatusBadRequest},
+		{fmt.Errorf("%w: set set1 has no layer \"wave\"", robohash.ErrInvalidAnimation), http.StatusBadRequest},
+		{fmt.Errorf("%w: set1/eyes.png", robohash.ErrAssetMissing), http.StatusInternalServerError},
+		{errMethodNotAllowed, http.StatusMethodNotAllowed},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{context.Canceled, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		writeError(rec, tc.err)
+
+		if rec.Code != tc.status {
+			t.Errorf("writeError(%v) status = %d, want %d", tc.err, rec.Code, tc.status)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("writeError(%v) Content-Type = %q", tc.err, ct)
+		}
+
+		var body errorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("writeError(%v) body is not JSON: %v", tc.err, err)
+		}
+		if body.Status != tc.status || body.Error == "" {
+			t.Errorf("writeError(%v) body = %+v", tc.err, body)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// explainResponse describes the avatar a text resolves to, using the same
+// recipe the image is rendered from.
+type explainResponse struct {
+	Text string `json:"text"`
+	*robohash.Recipe
+}
+
+func (s *server) explain(w http.ResponseWriter, r *http.Request, req robohash.Request) {
+	// The recipe depends on the same inputs as the image; the "json" format
+	// keeps its validator apart from every image one.
+	key := renderKey(s.gen.AssetFingerprint(), req, nil, "json", robohash.EncodeOptions{})
+	etag, modTime := avatarETag(key, "json"), s.lastModified()
+	if notModified(r, etag, modTime) {
+		setValidators(w, etag, modTime)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	recipe, err := s.gen.Resolve(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body, err := json.MarshalIndent(explainResponse{Text: req.Text, Recipe: recipe}, "", "  ")
+	if err != nil {
+		writeError(w, fmt.Errorf("error encoding recipe: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setValidators(w, etag, modTime)
+	w.Write(body)
+}
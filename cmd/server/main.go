@@ -1,136 +1,369 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/terem42/robohash/robohash"
 )
 
 var buildVersion = "HEAD"
 
-func generateETag(data []byte) string {
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+type server struct {
+	gen *robohash.Generator
+	// renderTimeout bounds the time spent generating and encoding one
+	// image, zero for no limit.
+	renderTimeout time.Duration
+	// profiles are the encoder profiles selectable with profile=.
+	profiles map[string]encodeParams
+	// maxEffort bounds the encoder effort a request may ask for.
+	maxEffort int
+	// favicon is the query of the robot served as /favicon.ico.
+	favicon url.Values
+	// cache holds encoded avatars, nil when disabled.
+	cache        responseCache
+	cacheBackend string
+	flights      flightGroup
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status": "ok", "version": "` + buildVersion + `", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
+	w.Write([]byte(`{"status": "ok", "version": "` + buildVersion + `", "assets": "` + s.gen.AssetFingerprint() + `", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
 }
 
-func hashHandler(w http.ResponseWriter, r *http.Request) {
+// faviconHandler serves the configured default robot as the server's own
+// icon.
+func (s *server) faviconHandler(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = s.favicon.Encode()
+	s.hashHandler(w, r2)
+}
+
+// statsHandler reports the output and decoded asset cache counters.
+func (s *server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := json.MarshalIndent(map[string]any{
+		"output_cache": s.outputStats(),
+		"asset_cache":  s.gen.CacheStats(),
+	}, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
 
-	path := strings.TrimPrefix(r.URL.Path, "/")
-	ext := filepath.Ext(path)
-	text := strings.TrimSuffix(path, filepath.Ext(path))
+func (s *server) hashHandler(w http.ResponseWriter, r *http.Request) {
 
-	if strings.HasPrefix(path, "favicon") {
-		http.NotFound(w, r)
+	query := r.URL.Query()
+	u, err := parseURL(r.URL.Path, query)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	if text == "" {
-		text = "example"
+	req, err := s.parseRequest(u.Text, query)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	query := r.URL.Query()
-	roboHash := robohash.RoboHash{
-		Text:  text,
-		Set:   query.Get("set"),
-		Size:  query.Get("size"),
-		BGSet: query.Get("bgset"),
+	if u.Explain {
+		s.explain(w, r, req)
+		return
 	}
 
-	img, err := roboHash.Generate()
+	anim, err := parseAnimation(query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating image: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer img.Close()
 
-	var imgBuf []byte
-	var contentType string
+	format := u.Format
+	if format == "" {
+		if anim != nil {
+			format = negotiateFormat(r.Header.Get("Accept"), animatedOrder, robohash.FormatGIF)
+		} else {
+			format = negotiateFormat(r.Header.Get("Accept"), negotiationOrder, robohash.FormatPNG)
+		}
+		w.Header().Set("Vary", "Accept")
+	}
+	if anim != nil && format != robohash.FormatWebP && format != robohash.FormatGIF {
+		writeError(w, fmt.Errorf("%w: %s cannot be animated, use webp or gif", robohash.ErrInvalidAnimation, format))
+		return
+	}
 
-	switch strings.ToLower(ext) {
-	case ".avif":
-		// Экспорт в AVIF
-		imgBuf, _, err = img.ExportAvif(&vips.AvifExportParams{
-			Quality:  85,    // Качество сжатия
-			Speed:    8,     // Скорость кодирования (0-8, больше = быстрее но хуже качество)
-			Lossless: false, // Сжатие с потерями
-		})
+	opts, err := s.encodeOptions(format, query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if format == robohash.FormatSVG {
+		// The document scales through its viewBox, one per text.
+		req.Size = ""
+	}
+	if format == robohash.FormatICO {
+		// Every icon is scaled down from the biggest one; a smaller or
+		// non-square render would be blown up or stretched.
+		largest := robohash.IconSizes[len(robohash.IconSizes)-1]
+		req.Size = fmt.Sprintf("%dx%d", largest, largest)
+	}
+
+	// The render itself runs under its own deadline in renderCached; this
+	// one bounds how long the request waits for it.
+	ctx := r.Context()
+	if s.renderTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.renderTimeout)
+		defer cancel()
+	}
+
+	// The validators only depend on the request, so a client holding a
+	// current copy is answered before any rendering.
+	key := renderKey(s.gen.AssetFingerprint(), req, anim, format, opts)
+	etag, modTime := avatarETag(key, format), s.lastModified()
+	if notModified(r, etag, modTime) {
+		setValidators(w, etag, modTime)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		s.head(ctx, w, req, anim, format, key, etag, modTime)
+		return
+	}
+
+	res, status, err := s.renderCached(ctx, key, func(ctx context.Context) (renderResult, error) {
+		body, skipped, err := s.render(ctx, req, anim, format, opts)
+		return renderResult{body: body, skipped: skipped}, err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	imgBuf, skipped := res.body, res.skipped
+
+	// Устанавливаем заголовки ответа
+	w.Header().Set("Content-Type", robohash.ContentType(format))
+	if len(skipped) > 0 {
+		// Do not let caches keep or revalidate an avatar with missing
+		// parts.
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robohash-Skipped", strconv.Itoa(len(skipped)))
+	} else {
+		setValidators(w, etag, modTime)
+	}
+	w.Header().Set("X-Robohash-Cache", string(status))
+	w.Header().Set("Content-Length", strconv.Itoa(len(imgBuf)))
+	w.Write(imgBuf)
+
+}
+
+// head answers a HEAD request with the status and headers GET would send,
+// worked out from the recipe instead of the image. Only a cache that knows
+// the length without fetching the avatar adds it; rendering or fetching
+// just to drop the body is not worth it.
+func (s *server) head(ctx context.Context, w http.ResponseWriter, req robohash.Request, anim *robohash.Animation, format robohash.Format, key, etag string, modTime time.Time) {
+	recipe, err := s.gen.Resolve(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	frames := 1
+	if anim != nil {
+		recipes, err := s.gen.FrameRecipes(recipe, *anim)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error encoding AVIF image: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
-		contentType = "image/avif"
-
-	case ".webp":
-		// Экспорт в WebP
-		imgBuf, _, err = img.ExportWebp(&vips.WebpExportParams{
-			Quality:         85,   // Качество для lossy
-			Lossless:        true, // Используем lossless для лучшего качества
-			NearLossless:    false,
-			ReductionEffort: 4, // Уровень оптимизации (0-6)
-		})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error encoding WEBP image: %v", err), http.StatusInternalServerError)
-			return
+		frames = len(recipes)
+	}
+
+	w.Header().Set("Content-Type", robohash.ContentType(format))
+	if missing := missingParts(recipe); missing > 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robohash-Skipped", strconv.Itoa(missing*frames))
+		w.Header().Set("X-Robohash-Cache", string(cacheMiss))
+		return
+	}
+
+	status := cacheMiss
+	if s.cache != nil {
+		if size, ok := s.cache.size(key); ok {
+			status = cacheHit
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	}
+	setValidators(w, etag, modTime)
+	w.Header().Set("X-Robohash-Cache", string(status))
+}
+
+// missingParts counts the layers a lenient render of recipe leaves out for
+// lack of files. Parts that fail to decode only show up while rendering.
+func missingParts(recipe *robohash.Recipe) int {
+	n := 0
+	for _, layer := range recipe.Layers {
+		if layer.File == "" {
+			n++
 		}
-		contentType = "image/webp"
-
-	case ".jpg", ".jpeg":
-		// Экспорт в JPEG
-		imgBuf, _, err = img.ExportJpeg(&vips.JpegExportParams{
-			Quality:        85,
-			Interlace:      false,
-			OptimizeCoding: true,
-			SubsampleMode:  vips.VipsForeignSubsampleAuto,
-		})
+	}
+	if recipe.BGSet != robohash.BackgroundNone && recipe.Background == "" {
+		n++
+	}
+	return n
+}
+
+// render produces the encoded avatar for req and the layers left out of
+// it. SVG documents are written from the recipe without rendering pixels.
+func (s *server) render(ctx context.Context, req robohash.Request, anim *robohash.Animation, format robohash.Format, opts robohash.EncodeOptions) ([]byte, []robohash.SkippedLayer, error) {
+	var buf bytes.Buffer
+
+	if format == robohash.FormatSVG {
+		recipe, err := s.gen.Resolve(ctx, req)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error encoding JPEG image: %v", err), http.StatusInternalServerError)
-			return
+			return nil, nil, err
 		}
-		contentType = "image/jpeg"
-
-	default:
-		// Экспорт в PNG (по умолчанию)
-		imgBuf, _, err = img.ExportPng(&vips.PngExportParams{
-			Compression: 6,     // Уровень сжатия PNG (0-9)
-			Interlace:   false, // Прогрессивная загрузка
-			Quality:     85,    // Качество (для палитровых изображений)
-		})
+		skipped, err := s.gen.EncodeSVG(ctx, recipe, &buf)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error encoding PNG image: %v", err), http.StatusInternalServerError)
-			return
+			return nil, nil, err
 		}
-		contentType = "image/png"
+		return buf.Bytes(), skipped, nil
 	}
 
-	// Устанавливаем заголовки ответа
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-	w.Header().Set("Content-Length", strconv.Itoa(len(imgBuf)))
-	w.Header().Set("ETag", `"`+generateETag(imgBuf)+`"`)
-	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
-	w.Write(imgBuf)
+	var avatar *robohash.Avatar
+	var err error
+	if anim == nil {
+		avatar, err = s.gen.Generate(ctx, req)
+	} else {
+		var recipe *robohash.Recipe
+		recipe, err = s.gen.Resolve(ctx, req)
+		if err == nil {
+			avatar, err = s.gen.RenderAnimation(ctx, recipe, *anim)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer avatar.Close()
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := robohash.Encode(avatar.Image, format, opts, &buf); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), avatar.Skipped, nil
+}
+
+// isTrue reports whether a query flag is switched on.
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// envInt reads a positive integer from the environment, falling back to def.
+func envInt(name string, def int64) int64 {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// envSize reads a non-negative integer from the environment, falling back
+// to def. Unlike envInt it accepts 0, which switches a feature off.
+func envSize(name string, def int64) int64 {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
 }
 
 func main() {
 	log.Printf("Robohash Go version %s", buildVersion)
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/", hashHandler)
+
+	maxSize := int(envInt("ROBOHASH_MAX_SIZE", robohash.DefaultMaxSize))
+	opts := []robohash.Option{
+		robohash.WithCache(robohash.NewImageCache(envInt("ROBOHASH_IMG_CACHE_SIZE", 100) * 1024 * 1024)),
+		robohash.WithMaxSize(maxSize, maxSize),
+		robohash.WithStrict(os.Getenv("ROBOHASH_STRICT") == "" || isTrue(os.Getenv("ROBOHASH_STRICT"))),
+	}
+	if dir := os.Getenv("ROBOHASH_ASSETS_DIR"); dir != "" {
+		log.Printf("Using assets from %s", dir)
+		opts = append(opts, robohash.WithAssetsDir(dir))
+	}
+	if set := os.Getenv("ROBOHASH_DEFAULT_SET"); set != "" {
+		opts = append(opts, robohash.WithDefaultSet(robohash.Set(set)))
+	}
+
+	gen, err := robohash.NewGenerator(opts...)
+	if err != nil {
+		log.Fatalf("Failed to load assets: %v", err)
+	}
+	log.Printf("Asset fingerprint %s", gen.AssetFingerprint())
+	s := &server{
+		gen:           gen,
+		renderTimeout: 10 * time.Second,
+		profiles:      defaultProfiles,
+		maxEffort:     int(envInt("ROBOHASH_MAX_EFFORT", robohash.MaxEffort)),
+	}
+	if s.maxEffort > robohash.MaxEffort {
+		s.maxEffort = robohash.MaxEffort
+	}
+	if name := os.Getenv("ROBOHASH_PROFILES"); name != "" {
+		profiles, err := loadProfiles(name)
+		if err != nil {
+			log.Fatalf("Failed to load encoder profiles: %v", err)
+		}
+		s.profiles = profiles
+	}
+	if value := os.Getenv("ROBOHASH_RENDER_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid ROBOHASH_RENDER_TIMEOUT: %v", err)
+		}
+		s.renderTimeout = timeout
+	}
+
+	s.cacheBackend = os.Getenv("ROBOHASH_OUTPUT_CACHE")
+	if s.cacheBackend == "" {
+		s.cacheBackend = "memory"
+	}
+	s.cache, err = newResponseCache(s.cacheBackend, envSize("ROBOHASH_OUTPUT_CACHE_SIZE", 64)*1024*1024)
+	if err != nil {
+		log.Fatalf("Failed to set up the output cache: %v", err)
+	}
+	if s.cache == nil {
+		log.Printf("Output cache disabled")
+	} else {
+		log.Printf("Output cache: %s", s.cacheBackend)
+	}
+
+	s.favicon = url.Values{"text": {"robohash"}}
+	if text := os.Getenv("ROBOHASH_FAVICON_TEXT"); text != "" {
+		s.favicon.Set("text", text)
+	}
+	if set := os.Getenv("ROBOHASH_FAVICON_SET"); set != "" {
+		if _, err := gen.ParseSet(set); err != nil {
+			log.Fatalf("Invalid ROBOHASH_FAVICON_SET: %v", err)
+		}
+		s.favicon.Set("set", set)
+	}
+
 	fmt.Println("Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", s.routes()))
 }
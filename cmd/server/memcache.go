@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// memcacheTimeout bounds one memcached round trip, so a slow cache never
+// costs more than a render.
+const memcacheTimeout = 500 * time.Millisecond
+
+// memcacheIdle is the number of idle connections kept per server.
+const memcacheIdle = 4
+
+// memcacheCache stores encoded avatars in memcached over the text protocol,
+// so replicas share their renders. Keys are spread over the servers by
+// their CRC-32. Memcached does its own eviction; only hits and misses are
+// counted here.
+type memcacheCache struct {
+	servers []*memcacheServer
+	ttl     time.Duration
+
+	mu     sync.Mutex
+	hits   uint64
+	misses uint64
+}
+
+// memcacheServer is one memcached address with its idle connections.
+type memcacheServer struct {
+	addr string
+
+	mu   sync.Mutex
+	idle []*memcacheConn
+}
+
+type memcacheConn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+var errMemcacheResponse = errors.New("unexpected memcached response")
+
+// newMemcacheCache creates a client for the comma separated host:port
+// addresses in addrs. Entries expire after ttl, zero for never.
+func newMemcacheCache(addrs string, ttl time.Duration) (*memcacheCache, error) {
+	c := &memcacheCache{ttl: ttl}
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid memcached address %q: %w", addr, err)
+		}
+		c.servers = append(c.servers, &memcacheServer{addr: addr})
+	}
+	if len(c.servers) == 0 {
+		return nil, errors.New("no memcached address")
+	}
+	return c, nil
+}
+
+// itemKey maps a render key to a memcached key, which must be short and
+// free of spaces and control characters.
+func (c *memcacheCache) itemKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "robohash:" + hex.EncodeToString(sum[:])
+}
+
+func (c *memcacheCache) server(key string) *memcacheServer {
+	return c.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(c.servers))]
+}
+
+func (c *memcacheCache) get(key string) ([]byte, bool) {
+	item := c.itemKey(key)
+	var body []byte
+	err := c.server(item).do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "get %s\r\n", item)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		var err error
+		body, err = readValue(rw.Reader, item)
+		return err
+	})
+	if err != nil {
+		log.Printf("Output cache: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if body == nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return body, true
+}
+
+// readValue reads the response to a get of item, nil when it is missing.
+func readValue(r *bufio.Reader, item string) ([]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "END" {
+		return nil, nil
+	}
+
+	// VALUE <key> <flags> <bytes>
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != "VALUE" || fields[1] != item {
+		return nil, fmt.Errorf("%w: %q", errMemcacheResponse, line)
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: %q", errMemcacheResponse, line)
+	}
+	body := make([]byte, size+2)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: unterminated value", errMemcacheResponse)
+	}
+	if line, err := readLine(r); err != nil {
+		return nil, err
+	} else if line != "END" {
+		return nil, fmt.Errorf("%w: %q", errMemcacheResponse, line)
+	}
+	return body[:size], nil
+}
+
+func (c *memcacheCache) add(key string, body []byte) {
+	item := c.itemKey(key)
+	err := c.server(item).do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "set %s 0 %d %d\r\n", item, memcacheExptime(c.ttl, time.Now()), len(body))
+		rw.Write(body)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			// SERVER_ERROR object too large for cache and the like; the
+			// connection is still in sync.
+			log.Printf("Output cache: memcached: %s", line)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Output cache: %v", err)
+	}
+}
+
+// size is unknown without fetching the item, which is what HEAD requests
+// avoid.
+func (c *memcacheCache) size(key string) (int64, bool) {
+	return 0, false
+}
+
+// memcacheMaxRelative is the longest expiry memcached takes as relative;
+// larger values are read as Unix timestamps.
+const memcacheMaxRelative = 30 * 24 * time.Hour
+
+// memcacheExptime returns the set exptime for entries stored at now that
+// expire after ttl, zero for never.
+func memcacheExptime(ttl time.Duration, now time.Time) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > memcacheMaxRelative {
+		return now.Add(ttl).Unix()
+	}
+	// Round up, a zero exptime would never expire.
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func (c *memcacheCache) stats() robohash.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return robohash.CacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+	}
+}
+
+// readLine reads one CRLF terminated response line.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// do runs one request on an idle or new connection. Connections that fail
+// are closed rather than returned, as they may be out of sync.
+func (s *memcacheServer) do(fn func(rw *bufio.ReadWriter) error) error {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	conn.nc.SetDeadline(time.Now().Add(memcacheTimeout))
+	if err := fn(conn.rw); err != nil {
+		conn.nc.Close()
+		return fmt.Errorf("memcached %s: %w", s.addr, err)
+	}
+	s.release(conn)
+	return nil
+}
+
+func (s *memcacheServer) conn() (*memcacheConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, nil
+	}
+	s.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", s.addr, memcacheTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("memcached %s: %w", s.addr, err)
+	}
+	return &memcacheConn{
+		nc: nc,
+		rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+func (s *memcacheServer) release(conn *memcacheConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= memcacheIdle {
+		conn.nc.Close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached serves get and set of the memcached text protocol from a
+// map.
+type fakeMemcached struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	items map[string][]byte
+	ttls  map[string]string
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMemcached{ln: ln, items: make(map[string][]byte), ttls: make(map[string]string)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "get":
+			m.mu.Lock()
+			body, ok := m.items[fields[1]]
+			m.mu.Unlock()
+			if ok {
+				fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(body), body)
+			}
+			io.WriteString(conn, "END\r\n")
+		case len(fields) == 5 && fields[0] == "set":
+			size, _ := strconv.Atoi(fields[4])
+			body := make([]byte, size+2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			m.mu.Lock()
+			m.items[fields[1]] = body[:size]
+			m.ttls[fields[1]] = fields[3]
+			m.mu.Unlock()
+			io.WriteString(conn, "STORED\r\n")
+		default:
+			io.WriteString(conn, "ERROR\r\n")
+		}
+	}
+}
+
+func TestMemcacheCache(t *testing.T) {
+	m := newFakeMemcached(t)
+	c, err := newMemcacheCache(m.ln.Addr().String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.get("a"); ok {
+		t.Error("get() hit on an empty cache")
+	}
+	body := []byte("avatar\r\nEND\r\n")
+	c.add("a", body)
+	if got, ok := c.get("a"); !ok || string(got) != string(body) {
+		t.Errorf("get(a) = %q, %v", got, ok)
+	}
+
+	m.mu.Lock()
+	ttl := m.ttls[c.itemKey("a")]
+	m.mu.Unlock()
+	if ttl != "3600" {
+		t.Errorf("set with expiry %s, want 3600", ttl)
+	}
+
+	// Beyond 30 days memcached reads the expiry as a Unix time.
+	long, err := newMemcacheCache(m.ln.Addr().String(), 8760*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(8760 * time.Hour).Unix()
+	long.add("b", body)
+	after := time.Now().Add(8760 * time.Hour).Unix()
+	m.mu.Lock()
+	exptime, _ := strconv.ParseInt(m.ttls[long.itemKey("b")], 10, 64)
+	m.mu.Unlock()
+	if exptime < before || exptime > after {
+		t.Errorf("set with expiry %d for a year, want a Unix time in [%d, %d]", exptime, before, after)
+	}
+	if stats := c.stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats() = %+v", stats)
+	}
+}
+
+func TestMemcacheCacheUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := newMemcacheCache(addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("a", []byte("avatar"))
+	if _, ok := c.get("a"); ok {
+		t.Error("get() hit without a server")
+	}
+}
+
+func TestMemcacheCacheServers(t *testing.T) {
+	if _, err := newMemcacheCache(" , ", 0); err == nil {
+		t.Error("newMemcacheCache() accepted no address")
+	}
+	if _, err := newMemcacheCache("localhost", 0); err == nil {
+		t.Error("newMemcacheCache() accepted an address without port")
+	}
+
+	a, b := newFakeMemcached(t), newFakeMemcached(t)
+	c, err := newMemcacheCache(a.ln.Addr().String()+", "+b.ln.Addr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		c.add(strconv.Itoa(i), []byte("avatar"))
+	}
+	for i := 0; i < 20; i++ {
+		if _, ok := c.get(strconv.Itoa(i)); !ok {
+			t.Errorf("get(%d) missed", i)
+		}
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	defer a.mu.Unlock()
+	defer b.mu.Unlock()
+	if len(a.items) == 0 || len(b.items) == 0 {
+		t.Errorf("keys not spread over servers: %d and %d", len(a.items), len(b.items))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// negotiationOrder lists the formats an Accept header can select, best
+// first.
+var negotiationOrder = []robohash.Format{
+	robohash.FormatAVIF,
+	robohash.FormatWebP,
+	robohash.FormatJXL,
+	robohash.FormatHEIF,
+	robohash.FormatPNG,
+	robohash.FormatJPEG,
+	robohash.FormatGIF,
+	robohash.FormatTIFF,
+}
+
+// animatedOrder lists the formats that keep the frames of an animation.
+var animatedOrder = []robohash.Format{
+	robohash.FormatWebP,
+	robohash.FormatGIF,
+}
+
+// mediaRange is one entry of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// negotiateFormat picks the output format among order for an Accept
+// header. The highest q-value wins, then the most specific range. Formats
+// listed explicitly with the same q-value go by order, while formats
+// accepted only through a wildcard give way to fallback, which every client
+// decodes.
+func negotiateFormat(accept string, order []robohash.Format, fallback robohash.Format) robohash.Format {
+	ranges := parseAccept(accept)
+
+	best, bestQ, bestSpecificity := fallback, 0.0, -1
+	for _, format := range order {
+		q, specificity := acceptQuality(ranges, robohash.ContentType(format))
+		if q == 0 {
+			continue
+		}
+
+		better := q > bestQ ||
+			(q == bestQ && specificity > bestSpecificity) ||
+			(q == bestQ && specificity == bestSpecificity && specificity < 2 && format == fallback)
+		if better {
+			best, bestQ, bestSpecificity = format, q, specificity
+		}
+	}
+	return best
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, entry := range strings.Split(accept, ",") {
+		params := strings.Split(entry, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+
+		r := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			r.q = q
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// acceptQuality returns the q-value of the most specific range matching
+// mediaType, with the specificity: 2 for an exact match, 1 for "type/*" and
+// 0 for "*/*". It returns a zero q-value when nothing matches.
+func acceptQuality(ranges []mediaRange, mediaType string) (q float64, specificity int) {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	specificity = -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity
+}
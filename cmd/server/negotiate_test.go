@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   robohash.Format
+	}{
+		{"", robohash.FormatPNG},
+		{"*/*", robohash.FormatPNG},
+		{"image/*", robohash.FormatPNG},
+		{"text/html", robohash.FormatPNG},
+		// Chrome and Firefox image requests.
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", robohash.FormatAVIF},
+		{"image/avif,image/webp,*/*", robohash.FormatAVIF},
+		{"image/webp,image/png,*/*;q=0.5", robohash.FormatWebP},
+		{"image/avif;q=0.5,image/webp;q=0.9,image/*;q=0.1", robohash.FormatWebP},
+		{"image/jpeg", robohash.FormatJPEG},
+		{"image/jpeg,image/png;q=0.9", robohash.FormatJPEG},
+		{"image/png;q=0.5, image/*", robohash.FormatAVIF},
+		{"image/avif;q=0, image/*", robohash.FormatPNG},
+		{"IMAGE/WEBP ; Q=0.8, image/png;q=0.7", robohash.FormatWebP},
+		{"image/webp;q=abc,image/jpeg;q=0.1", robohash.FormatJPEG},
+		{"image/png;q=0,image/jpeg;q=0", robohash.FormatPNG},
+		// Safari image requests.
+		{"image/webp,image/avif,image/jxl,image/heic,image/heic-sequence,video/*;q=0.8,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5", robohash.FormatAVIF},
+		{"image/jxl,image/heic,image/png;q=0.9", robohash.FormatJXL},
+		{"image/gif", robohash.FormatGIF},
+		{"image/tiff,image/gif;q=0.5", robohash.FormatTIFF},
+	}
+
+	for _, tc := range tests {
+		if got := negotiateFormat(tc.accept, negotiationOrder, robohash.FormatPNG); got != tc.want {
+			t.Errorf("negotiateFormat(%q) = %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestNegotiateAnimatedFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   robohash.Format
+	}{
+		{"", robohash.FormatGIF},
+		{"*/*", robohash.FormatGIF},
+		{"image/avif,image/webp,*/*", robohash.FormatWebP},
+		{"image/avif,image/png", robohash.FormatGIF},
+		{"image/webp;q=0.5,image/gif", robohash.FormatGIF},
+	}
+
+	for _, tc := range tests {
+		if got := negotiateFormat(tc.accept, animatedOrder, robohash.FormatGIF); got != tc.want {
+			t.Errorf("negotiateFormat(%q, animated) = %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// renderResult is an encoded avatar with the layers left out of it.
+type renderResult struct {
+	body    []byte
+	skipped []robohash.SkippedLayer
+}
+
+// renderKey identifies an encoded avatar rendered from the assets with the
+// given fingerprint. Avatars are deterministic, so equal keys always produce
+// equal bytes, and changed assets never hit entries of the old ones.
+func renderKey(assets string, req robohash.Request, anim *robohash.Animation, format robohash.Format, opts robohash.EncodeOptions) string {
+	key := fmt.Sprintf("%s|%q|%s|%s|%s|%s|%+v", assets, req.Text, req.Set, req.Size, req.BGSet, format, opts)
+	if anim != nil {
+		key += fmt.Sprintf("|%+v", *anim)
+	}
+	return key
+}
+
+// responseCache stores encoded avatars by render key. Backends treat their
+// own failures as misses, so a broken cache only costs renders.
+type responseCache interface {
+	// get returns the avatar stored under key. The returned slice must not
+	// be modified.
+	get(key string) ([]byte, bool)
+	// add stores body under key. The cache may keep body, so it must not
+	// be modified afterwards.
+	add(key string, body []byte)
+	// size returns the length of the avatar stored under key, if it is
+	// known without fetching the avatar. It leaves the counters and the
+	// eviction order alone.
+	size(key string) (int64, bool)
+	// stats returns the cache counters. Remote backends only know their
+	// own hits and misses.
+	stats() robohash.CacheStats
+}
+
+// newResponseCache sets up the output cache backend named by
+// ROBOHASH_OUTPUT_CACHE. Local backends hold at most maxBytes; a zero size
+// or the "none" backend disables the cache and returns nil.
+func newResponseCache(backend string, maxBytes int64) (responseCache, error) {
+	switch backend {
+	case "none", "off":
+		return nil, nil
+	case "memory":
+		if maxBytes == 0 {
+			return nil, nil
+		}
+		return newMemoryCache(maxBytes), nil
+	case "disk":
+		if maxBytes == 0 {
+			return nil, nil
+		}
+		dir := os.Getenv("ROBOHASH_OUTPUT_CACHE_DIR")
+		if dir == "" {
+			return nil, errors.New("ROBOHASH_OUTPUT_CACHE_DIR is not set")
+		}
+		return newDiskCache(dir, maxBytes)
+	case "memcached":
+		addrs := os.Getenv("ROBOHASH_MEMCACHED_ADDR")
+		if addrs == "" {
+			return nil, errors.New("ROBOHASH_MEMCACHED_ADDR is not set")
+		}
+		var ttl time.Duration
+		if value := os.Getenv("ROBOHASH_MEMCACHED_TTL"); value != "" {
+			var err error
+			if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+				return nil, fmt.Errorf("invalid ROBOHASH_MEMCACHED_TTL %q", value)
+			}
+		}
+		return newMemcacheCache(addrs, ttl)
+	}
+	return nil, fmt.Errorf("unknown output cache backend %q, use memory, disk, memcached or none", backend)
+}
+
+// memoryCache is an in-process LRU of encoded avatars bounded by their total
+// size.
+type memoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type memoryEntry struct {
+	key  string
+	body []byte
+}
+
+// newMemoryCache creates a cache holding at most maxBytes of encoded
+// avatars.
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits++
+	return el.Value.(*memoryEntry).body, true
+}
+
+func (c *memoryCache) add(key string, body []byte) {
+	size := int64(len(body))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size > c.maxBytes {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&memoryEntry{key: key, body: body})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *memoryCache) size(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		return int64(len(el.Value.(*memoryEntry).body)), true
+	}
+	return 0, false
+}
+
+func (c *memoryCache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*memoryEntry)
+	delete(c.items, entry.key)
+	c.bytes -= int64(len(entry.body))
+}
+
+func (c *memoryCache) stats() robohash.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return robohash.CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+// flight is a render in progress.
+type flight struct {
+	done chan struct{}
+	res  renderResult
+	err  error
+	// waiters counts the callers still waiting; the last one to give up
+	// cancels the render.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent renders of the same key, so a burst of
+// requests for one avatar renders it once.
+type flightGroup struct {
+	mu        sync.Mutex
+	flights   map[string]*flight
+	coalesced uint64
+}
+
+// do runs fn once for all concurrent callers with the same key. A caller
+// stops waiting when its ctx is done; the render goes on for the others and
+// is canceled only when no caller is left. shared reports whether the
+// result came from another caller's render. A panic in fn is returned to
+// every caller as an error.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (renderResult, error)) (res renderResult, shared bool, err error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok {
+		g.coalesced++
+	} else {
+		if g.flights == nil {
+			g.flights = make(map[string]*flight)
+		}
+		renderCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go g.run(renderCtx, key, f, fn)
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, ok, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			// Later callers start afresh instead of joining a canceled
+			// render.
+			g.forget(key, f)
+		}
+		g.mu.Unlock()
+		return renderResult{}, ok, ctx.Err()
+	}
+}
+
+// run renders one flight and wakes its callers.
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func(context.Context) (renderResult, error)) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("Render panic: %v\n%s", v, debug.Stack())
+			f.res, f.err = renderResult{}, fmt.Errorf("render panic: %v", v)
+		}
+		f.cancel()
+		g.mu.Lock()
+		g.forget(key, f)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.res, f.err = fn(ctx)
+}
+
+// forget drops f from the group unless a newer flight replaced it.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// cacheStatus tells how a response was produced, for X-Robohash-Cache.
+type cacheStatus string
+
+const (
+	cacheHit       cacheStatus = "hit"
+	cacheMiss      cacheStatus = "miss"
+	cacheCoalesced cacheStatus = "coalesced"
+)
+
+// renderCached returns the encoded avatar for key from the output cache,
+// from a render already in flight, or from render. A client hanging up does
+// not fail the requests coalesced onto its render; the render is canceled
+// once all of them are gone, and in any case at its own deadline.
+func (s *server) renderCached(ctx context.Context, key string, render func(context.Context) (renderResult, error)) (renderResult, cacheStatus, error) {
+	if s.cache != nil {
+		if body, ok := s.cache.get(key); ok {
+			return renderResult{body: body}, cacheHit, nil
+		}
+	}
+
+	res, shared, err := s.flights.do(ctx, key, func(ctx context.Context) (renderResult, error) {
+		if s.renderTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.renderTimeout)
+			defer cancel()
+		}
+
+		res, err := render(ctx)
+		if err == nil && s.cache != nil && len(res.skipped) == 0 {
+			s.cache.add(key, res.body)
+		}
+		return res, err
+	})
+	if shared {
+		return res, cacheCoalesced, err
+	}
+	return res, cacheMiss, err
+}
+
+// outputStats reports the output cache and coalescing counters.
+type outputStats struct {
+	Enabled bool `json:"enabled"`
+	// Backend is the configured ROBOHASH_OUTPUT_CACHE backend.
+	Backend string `json:"backend,omitempty"`
+	robohash.CacheStats
+	Coalesced uint64 `json:"coalesced"`
+}
+
+func (s *server) outputStats() outputStats {
+	s.flights.mu.Lock()
+	stats := outputStats{Coalesced: s.flights.coalesced}
+	s.flights.mu.Unlock()
+
+	if s.cache != nil {
+		stats.Enabled = true
+		stats.Backend = s.cacheBackend
+		stats.CacheStats = s.cache.stats()
+	}
+	return stats
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := newMemoryCache(10)
+	c.add("a", []byte("aaaa"))
+	c.add("b", []byte("bbbb"))
+	c.get("a")
+	c.add("c", []byte("cccc"))
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+
+	c.add("big", make([]byte, 11))
+	if _, ok := c.get("big"); ok {
+		t.Error("entry larger than the cache was stored")
+	}
+
+	stats := c.stats()
+	if stats.Entries != 2 || stats.Bytes != 8 || stats.Evictions != 1 || stats.Hits != 3 || stats.Misses != 2 {
+		t.Errorf("stats() = %+v", stats)
+	}
+}
+
+func TestMemoryCacheSize(t *testing.T) {
+	c := newMemoryCache(10)
+	c.add("a", []byte("aaaa"))
+	c.add("b", []byte("bbbb"))
+
+	if size, ok := c.size("a"); !ok || size != 4 {
+		t.Errorf("size(a) = %d, %v", size, ok)
+	}
+	if _, ok := c.size("missing"); ok {
+		t.Error("size(missing) found an entry")
+	}
+
+	// size must not refresh a, so it is still the one evicted.
+	c.add("c", []byte("cccc"))
+	if _, ok := c.size("a"); ok {
+		t.Error("size refreshed the least recently used entry a")
+	}
+	if stats := c.stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("size counted as a lookup: %+v", stats)
+	}
+}
+
+func TestRenderKey(t *testing.T) {
+	req := robohash.Request{Text: "alice", Set: robohash.Set1}
+	opts := robohash.DefaultEncodeOptions(robohash.FormatPNG)
+	base := renderKey("fp", req, nil, robohash.FormatPNG, opts)
+
+	keys := map[string]string{
+		"text":   renderKey("fp", robohash.Request{Text: "bob", Set: robohash.Set1}, nil, robohash.FormatPNG, opts),
+		"size":   renderKey("fp", robohash.Request{Text: "alice", Set: robohash.Set1, Size: "64x64"}, nil, robohash.FormatPNG, opts),
+		"format": renderKey("fp", req, nil, robohash.FormatWebP, opts),
+		"opts":   renderKey("fp", req, nil, robohash.FormatPNG, robohash.EncodeOptions{Quality: 50}),
+		"anim":   renderKey("fp", req, &robohash.Animation{Layer: "eyes"}, robohash.FormatPNG, opts),
+		"assets": renderKey("other", req, nil, robohash.FormatPNG, opts),
+	}
+	for name, key := range keys {
+		if key == base {
+			t.Errorf("renderKey() ignores the %s", name)
+		}
+	}
+	if renderKey("fp", req, nil, robohash.FormatPNG, opts) != base {
+		t.Error("renderKey() is not deterministic")
+	}
+}
+
+func TestRenderCachedCoalesces(t *testing.T) {
+	s := &server{cache: newMemoryCache(1024)}
+
+	var renders atomic.Int32
+	release := make(chan struct{})
+	render := func(ctx context.Context) (renderResult, error) {
+		renders.Add(1)
+		<-release
+		return renderResult{body: []byte("avatar")}, nil
+	}
+
+	var wg sync.WaitGroup
+	statuses := make(chan cacheStatus, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, status, err := s.renderCached(context.Background(), "alice", render)
+			if err != nil || string(res.body) != "avatar" {
+				t.Errorf("renderCached() = %q, %v", res.body, err)
+			}
+			statuses <- status
+		}()
+	}
+	for s.outputStats().Coalesced < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(statuses)
+
+	counts := map[cacheStatus]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if renders.Load() != 1 || counts[cacheMiss] != 1 || counts[cacheCoalesced] != 7 {
+		t.Errorf("%d renders, statuses %v, want 1 render, 1 miss and 7 coalesced", renders.Load(), counts)
+	}
+
+	if _, status, _ := s.renderCached(context.Background(), "alice", render); status != cacheHit || renders.Load() != 1 {
+		t.Errorf("second renderCached() status %s after %d renders, want a cache hit", status, renders.Load())
+	}
+}
+
+func TestRenderCachedWaiterCanceled(t *testing.T) {
+	s := &server{}
+
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	render := func(ctx context.Context) (renderResult, error) {
+		<-release
+		return renderResult{body: []byte("avatar")}, ctx.Err()
+	}
+
+	go func() {
+		_, _, err := s.renderCached(context.Background(), "alice", render)
+		done <- err
+	}()
+	for {
+		s.flights.mu.Lock()
+		n := len(s.flights.flights)
+		s.flights.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := s.renderCached(ctx, "alice", render); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled waiter got %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("first caller failed after a waiter left: %v", err)
+	}
+}
+
+func TestRenderCachedLastWaiterCancels(t *testing.T) {
+	s := &server{}
+
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	render := func(ctx context.Context) (renderResult, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return renderResult{}, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := s.renderCached(ctx, "alice", render)
+		done <- err
+	}()
+	<-started
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller got %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("render context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("render went on after its only caller left")
+	}
+}
+
+func TestRenderCachedPanic(t *testing.T) {
+	s := &server{cache: newMemoryCache(1024)}
+	render := func(ctx context.Context) (renderResult, error) {
+		panic("vips exploded")
+	}
+
+	if _, _, err := s.renderCached(context.Background(), "alice", render); err == nil || !strings.Contains(err.Error(), "vips exploded") {
+		t.Errorf("renderCached() error = %v, want the panic", err)
+	}
+	if _, ok := s.cache.get("alice"); ok {
+		t.Error("panicked render was cached")
+	}
+}
+
+func TestRenderCachedSkipsIncomplete(t *testing.T) {
+	s := &server{cache: newMemoryCache(1024)}
+	render := func(ctx context.Context) (renderResult, error) {
+		return renderResult{body: []byte("partial"), skipped: []robohash.SkippedLayer{{Name: "eyes"}}}, nil
+	}
+	s.renderCached(context.Background(), "alice", render)
+
+	if _, ok := s.cache.get("alice"); ok {
+		t.Error("avatar with skipped layers was cached")
+	}
+
+	failing := func(ctx context.Context) (renderResult, error) {
+		return renderResult{}, fmt.Errorf("%w: eyes", robohash.ErrAssetMissing)
+	}
+	if _, _, err := s.renderCached(context.Background(), "bob", failing); !errors.Is(err, robohash.ErrAssetMissing) {
+		t.Errorf("renderCached() error = %v, want ErrAssetMissing", err)
+	}
+	if _, ok := s.cache.get("bob"); ok {
+		t.Error("failed render was cached")
+	}
+}
+
+func TestNewResponseCache(t *testing.T) {
+	t.Setenv("ROBOHASH_OUTPUT_CACHE_DIR", t.TempDir())
+	t.Setenv("ROBOHASH_MEMCACHED_ADDR", "127.0.0.1:11211")
+
+	tests := []struct {
+		backend string
+		size    int64
+		want    string
+	}{
+		{"memory", 1024, "*main.memoryCache"},
+		{"memory", 0, "<nil>"},
+		{"disk", 1024, "*main.diskCache"},
+		{"memcached", 0, "*main.memcacheCache"},
+		{"none", 1024, "<nil>"},
+	}
+	for _, tt := range tests {
+		c, err := newResponseCache(tt.backend, tt.size)
+		if err != nil {
+			t.Errorf("newResponseCache(%q) error: %v", tt.backend, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", c); got != tt.want {
+			t.Errorf("newResponseCache(%q, %d) = %s, want %s", tt.backend, tt.size, got, tt.want)
+		}
+	}
+
+	if _, err := newResponseCache("redis", 1024); err == nil {
+		t.Error("newResponseCache() accepted an unknown backend")
+	}
+	t.Setenv("ROBOHASH_MEMCACHED_TTL", "soon")
+	if _, err := newResponseCache("memcached", 0); err == nil {
+		t.Error("newResponseCache() accepted an invalid TTL")
+	}
+}
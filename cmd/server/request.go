@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+// defaultText is hashed when the URL names no text.
+const defaultText = "example"
+
+// urlRequest is what a request URL asks for, before the avatar parameters
+// are validated.
+type urlRequest struct {
+	// Text is the text to hash.
+	Text string
+	// Format is the output format, empty when the URL names none.
+	Format robohash.Format
+	// Explain asks for the recipe instead of the image.
+	Explain bool
+}
+
+// parseURL reads the text and output format of a request:
+//
+//	/{text}[.{ext}][?text={text}][&format={format}][&ignoreext=1][&explain=1]
+//
+// Only a known image extension or .json is split off the path, so the
+// ".com" of an email address stays part of the text. With ignoreext the
+// whole path is the text. text= replaces the path text, format= overrides
+// the extension.
+func parseURL(urlPath string, query url.Values) (urlRequest, error) {
+	var u urlRequest
+
+	text := strings.TrimPrefix(urlPath, "/")
+	if !isTrue(query.Get("ignoreext")) {
+		if ext := path.Ext(text); ext != "" {
+			if strings.EqualFold(ext, ".json") {
+				u.Explain = true
+				text = strings.TrimSuffix(text, ext)
+			} else if format, err := robohash.FormatFromExtension(ext); err == nil {
+				u.Format = format
+				text = strings.TrimSuffix(text, ext)
+			}
+		}
+	}
+
+	if value := query.Get("format"); value != "" {
+		format, err := robohash.ParseFormat(value)
+		if err != nil {
+			return urlRequest{}, err
+		}
+		u.Format = format
+	}
+
+	if value := query.Get("text"); value != "" {
+		text = value
+	}
+	if text == "" {
+		text = defaultText
+	}
+	u.Text = text
+
+	if isTrue(query.Get("explain")) {
+		u.Explain = true
+	}
+	return u, nil
+}
+
+// parseAnimation reads the animate, frames and delay query parameters. It
+// returns nil when no animation is asked for. The layer is checked once the
+// set is resolved.
+func parseAnimation(query url.Values) (*robohash.Animation, error) {
+	layer := query.Get("animate")
+	if layer == "" {
+		return nil, nil
+	}
+
+	anim := &robohash.Animation{Layer: layer}
+	if value := query.Get("frames"); value != "" {
+		frames, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: frames=%q is not a number", robohash.ErrInvalidAnimation, value)
+		}
+		anim.Frames = frames
+	}
+	if value := query.Get("delay"); value != "" {
+		delay, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: delay=%q is not a number of milliseconds", robohash.ErrInvalidAnimation, value)
+		}
+		anim.Delay = time.Duration(delay) * time.Millisecond
+	}
+	// Fill in the defaults before the animation keys caches and ETags.
+	if err := anim.Validate(); err != nil {
+		return nil, err
+	}
+	return anim, nil
+}
+
+// parseRequest validates the query parameters before any image is touched.
+func (s *server) parseRequest(text string, query url.Values) (robohash.Request, error) {
+	set, err := s.gen.ParseSet(query.Get("set"))
+	if err != nil {
+		return robohash.Request{}, err
+	}
+
+	bgSet, err := s.gen.ParseBackgroundSet(query.Get("bgset"))
+	if err != nil {
+		return robohash.Request{}, err
+	}
+
+	size := query.Get("size")
+	if size != "" {
+		if _, _, err := s.gen.ParseSize(size); err != nil {
+			return robohash.Request{}, err
+		}
+	}
+
+	return robohash.Request{Text: text, Set: set, Size: size, BGSet: bgSet}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		target  string
+		text    string
+		format  robohash.Format
+		explain bool
+	}{
+		{"/", defaultText, "", false},
+		{"/alice", "alice", "", false},
+		{"/alice.png", "alice", robohash.FormatPNG, false},
+		{"/alice.JPG", "alice", robohash.FormatJPEG, false},
+		{"/alice.webp", "alice", robohash.FormatWebP, false},
+		{"/.avif", defaultText, robohash.FormatAVIF, false},
+		{"/alice.gif", "alice", robohash.FormatGIF, false},
+		{"/alice.tif", "alice", robohash.FormatTIFF, false},
+		{"/alice.heic", "alice", robohash.FormatHEIF, false},
+		{"/alice.jxl", "alice", robohash.FormatJXL, false},
+		{"/favicon.ico?text=acme", "acme", robohash.FormatICO, false},
+		{"/alice.svg", "alice", robohash.FormatSVG, false},
+		{"/alice.json", "alice", "", true},
+		{"/alice?explain=1", "alice", "", true},
+		{"/dave@email.com", "dave@email.com", "", false},
+		{"/dave@email.com.png", "dave@email.com", robohash.FormatPNG, false},
+		{"/v1.2.3", "v1.2.3", "", false},
+		{"/a/b.png", "a/b", robohash.FormatPNG, false},
+		{"/a.png/b", "a.png/b", "", false},
+		{"/alice.png?ignoreext=1", "alice.png", "", false},
+		{"/alice.png?ignoreext=true&format=webp", "alice.png", robohash.FormatWebP, false},
+		{"/alice.png?format=avif", "alice", robohash.FormatAVIF, false},
+		{"/avatar.png?text=a%2Fb.c", "a/b.c", robohash.FormatPNG, false},
+		{"/?text=%20", " ", "", false},
+	}
+
+	for _, tc := range tests {
+		target, err := url.Parse(tc.target)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) failed: %v", tc.target, err)
+		}
+
+		u, err := parseURL(target.Path, target.Query())
+		if err != nil {
+			t.Errorf("parseURL(%q) failed: %v", tc.target, err)
+			continue
+		}
+		if u.Text != tc.text || u.Format != tc.format || u.Explain != tc.explain {
+			t.Errorf("parseURL(%q) = %+v, want text=%q format=%q explain=%v", tc.target, u, tc.text, tc.format, tc.explain)
+		}
+	}
+}
+
+func TestParseURLUnknownFormat(t *testing.T) {
+	if _, err := parseURL("/alice", url.Values{"format": {"bmp"}}); err == nil {
+		t.Error("parseURL() accepted format=bmp")
+	}
+}
+
+func TestParseAnimation(t *testing.T) {
+	anim, err := parseAnimation(url.Values{})
+	if err != nil || anim != nil {
+		t.Errorf("parseAnimation() without animate = %+v, %v, want nil", anim, err)
+	}
+
+	anim, err = parseAnimation(url.Values{"animate": {"eyes"}, "frames": {"6"}, "delay": {"150"}})
+	if err != nil {
+		t.Fatalf("parseAnimation() failed: %v", err)
+	}
+	if want := (robohash.Animation{Layer: "eyes", Frames: 6, Delay: 150 * time.Millisecond}); *anim != want {
+		t.Errorf("parseAnimation() = %+v, want %+v", *anim, want)
+	}
+
+	anim, err = parseAnimation(url.Values{"animate": {"eyes"}})
+	if err != nil {
+		t.Fatalf("parseAnimation() failed: %v", err)
+	}
+	if want := (robohash.Animation{Layer: "eyes", Frames: robohash.DefaultFrames, Delay: robohash.DefaultFrameDelay}); *anim != want {
+		t.Errorf("parseAnimation() = %+v, want the defaults %+v", *anim, want)
+	}
+
+	for _, query := range []url.Values{
+		{"animate": {"eyes"}, "frames": {"many"}},
+		{"animate": {"eyes"}, "delay": {"1s"}},
+		{"animate": {"eyes"}, "frames": {"99"}},
+		{"animate": {"eyes"}, "delay": {"5"}},
+	} {
+		if _, err := parseAnimation(query); !errors.Is(err, robohash.ErrInvalidAnimation) {
+			t.Errorf("parseAnimation(%v) = %v, want ErrInvalidAnimation", query, err)
+		}
+	}
+}
+
+func FuzzParseURL(f *testing.F) {
+	for _, seed := range []string{"", "/", "/alice.png", "/dave@email.com", "/a/b.c.webp", "/.json", "/..", "/x.PNG.png"} {
+		f.Add(seed, "", false)
+	}
+	f.Add("/alice.png", "webp", true)
+
+	f.Fuzz(func(t *testing.T, urlPath, format string, ignoreExt bool) {
+		query := url.Values{}
+		if format != "" {
+			query.Set("format", format)
+		}
+		if ignoreExt {
+			query.Set("ignoreext", "1")
+		}
+
+		u, err := parseURL(urlPath, query)
+		if err != nil {
+			if _, ferr := robohash.ParseFormat(format); ferr == nil {
+				t.Fatalf("parseURL(%q) failed with a valid format %q: %v", urlPath, format, err)
+			}
+			return
+		}
+		if u.Text == "" {
+			t.Fatalf("parseURL(%q) returned an empty text", urlPath)
+		}
+
+		trimmed := strings.TrimPrefix(urlPath, "/")
+		if ignoreExt {
+			if trimmed != "" && u.Text != trimmed {
+				t.Fatalf("parseURL(%q) with ignoreext = %q, want the whole path", urlPath, u.Text)
+			}
+			return
+		}
+
+		// The text is the path, minus at most one recognised extension.
+		if trimmed != "" && u.Text != defaultText && u.Text != trimmed {
+			ext := strings.TrimPrefix(trimmed, u.Text)
+			if !strings.HasPrefix(trimmed, u.Text) || ext != path.Ext(trimmed) {
+				t.Fatalf("parseURL(%q) text = %q, not the path minus its extension", urlPath, u.Text)
+			}
+			if _, err := robohash.ParseFormat(ext); err != nil && !strings.EqualFold(ext, ".json") {
+				t.Fatalf("parseURL(%q) stripped unknown extension %q", urlPath, ext)
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// allowedMethods lists the methods every path answers.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
+// errMethodNotAllowed rejects methods other than allowedMethods.
+var errMethodNotAllowed = errors.New("method not allowed, use " + allowedMethods)
+
+// routes returns the server handler. GET patterns also match HEAD; any
+// other method falls through to the catch-all pattern and gets 405.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.healthHandler)
+	mux.HandleFunc("GET /favicon.ico", s.faviconHandler)
+	mux.HandleFunc("GET /stats", s.statsHandler)
+	mux.HandleFunc("GET /", s.hashHandler)
+	mux.HandleFunc("OPTIONS /", preflightHandler)
+	mux.HandleFunc("/", methodNotAllowedHandler)
+	return withCORS(mux)
+}
+
+// withCORS lets pages on any origin read responses, including the
+// validators and cache headers.
+func withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Robohash-Cache, X-Robohash-Skipped")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// preflightHandler answers CORS preflight requests, and plain OPTIONS
+// requests with the allowed methods.
+func preflightHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", allowedMethods)
+	w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, If-None-Match, If-Modified-Since")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", allowedMethods)
+	writeError(w, errMethodNotAllowed)
+}
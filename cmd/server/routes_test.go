@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/terem42/robohash/robohash"
+)
+
+func TestRoutesMethods(t *testing.T) {
+	h := (&server{}).routes()
+
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPost, "/alice.png"},
+		{http.MethodDelete, "/alice"},
+		{http.MethodPut, "/health"},
+		{http.MethodPatch, "/stats"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, rec.Code, http.StatusMethodNotAllowed)
+		}
+		if got := rec.Header().Get("Allow"); got != allowedMethods {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.path, got, allowedMethods)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s %s Content-Type = %q, want a JSON error", tt.method, tt.path, got)
+		}
+	}
+}
+
+func TestRoutesPreflight(t *testing.T) {
+	h := (&server{}).routes()
+
+	r := httptest.NewRequest(http.MethodOptions, "/alice.png", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	want := map[string]string{
+		"Allow":                        allowedMethods,
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": allowedMethods,
+		"Access-Control-Max-Age":       "86400",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestRoutesHeadMatchesGet(t *testing.T) {
+	gen, err := robohash.NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	h := (&server{gen: gen, profiles: defaultProfiles, maxEffort: robohash.MaxEffort}).routes()
+
+	for _, path := range []string{
+		"/alice.png?animate=nose&set=set1",
+		"/alice.webp?animate=eyes&set=set1&frames=99",
+		"/alice.png?set=set9",
+		"/alice.png?bgset=bg9",
+	} {
+		get := httptest.NewRecorder()
+		h.ServeHTTP(get, httptest.NewRequest(http.MethodGet, path, nil))
+		head := httptest.NewRecorder()
+		h.ServeHTTP(head, httptest.NewRequest(http.MethodHead, path, nil))
+
+		if get.Code < 400 {
+			t.Errorf("GET %s status = %d, want an error", path, get.Code)
+		}
+		if head.Code != get.Code {
+			t.Errorf("HEAD %s status = %d, GET status = %d", path, head.Code, get.Code)
+		}
+		if got := head.Header().Get("ETag"); got != "" {
+			t.Errorf("HEAD %s ETag = %s on an error", path, got)
+		}
+	}
+}
+
+func TestRoutesHeadIncomplete(t *testing.T) {
+	var part bytes.Buffer
+	png.Encode(&part, image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	gen, err := robohash.NewGenerator(robohash.WithAssets(fstest.MapFS{
+		"acme/000#Head/1.png":     {Data: part.Bytes()},
+		"backgrounds/empty/.keep": {},
+	}), robohash.WithDefaultSet("acme"))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	h := (&server{gen: gen, profiles: defaultProfiles, maxEffort: robohash.MaxEffort}).routes()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/alice.png?bgset=empty", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	if got := rec.Header().Get("X-Robohash-Skipped"); got != "1" {
+		t.Errorf("X-Robohash-Skipped = %q, want 1", got)
+	}
+	if got := rec.Header().Get("ETag"); got != "" {
+		t.Errorf("ETag = %s for an incomplete avatar", got)
+	}
+}
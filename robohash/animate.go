@@ -0,0 +1,143 @@
+package robohash
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	// DefaultFrames is the frame count of an Animation that leaves it zero.
+	DefaultFrames = 4
+	// MaxFrames bounds the frame count, as every frame is rendered in full.
+	MaxFrames = 32
+	// DefaultFrameDelay is the frame delay of an Animation that leaves it
+	// zero.
+	DefaultFrameDelay = 200 * time.Millisecond
+)
+
+// Animation asks for an avatar that keeps the layers picked by the hash
+// and cycles one layer through its parts, e.g. "eyes" to blink or "mouth"
+// to talk. Only WebP and GIF keep the frames when encoded.
+type Animation struct {
+	// Layer is the manifest name of the cycled layer.
+	Layer string
+	// Frames is the number of frames, 2 to MaxFrames. The first frame shows
+	// the part picked by the hash, the next ones the parts after it.
+	Frames int
+	// Delay is how long each frame is shown, 20ms to 10s.
+	Delay time.Duration
+}
+
+// Validate checks the frame count and delay, filling in the defaults, so
+// equal animations compare equal however they were spelled.
+func (a *Animation) Validate() error {
+	if a.Frames == 0 {
+		a.Frames = DefaultFrames
+	}
+	if a.Delay == 0 {
+		a.Delay = DefaultFrameDelay
+	}
+	if a.Frames < 2 || a.Frames > MaxFrames {
+		return fmt.Errorf("%w: %d frames outside 2-%d", ErrInvalidAnimation, a.Frames, MaxFrames)
+	}
+	if a.Delay < 20*time.Millisecond || a.Delay > 10*time.Second {
+		return fmt.Errorf("%w: frame delay %v outside 20ms-10s", ErrInvalidAnimation, a.Delay)
+	}
+	return nil
+}
+
+// FrameRecipes returns one recipe per frame of anim, each a copy of recipe
+// with the animated layer set to the part of that frame.
+func (g *Generator) FrameRecipes(recipe *Recipe, anim Animation) ([]*Recipe, error) {
+	if err := anim.Validate(); err != nil {
+		return nil, err
+	}
+
+	layer := -1
+	for i, l := range recipe.Layers {
+		if l.Name == anim.Layer {
+			layer = i
+			break
+		}
+	}
+	if layer < 0 {
+		return nil, fmt.Errorf("%w: set %s has no layer %q", ErrInvalidAnimation, recipe.Set, anim.Layer)
+	}
+
+	idx, err := g.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	chosen := recipe.Layers[layer]
+	if chosen.File == "" {
+		return nil, fmt.Errorf("%w: no part for layer %s", ErrAssetMissing, chosen.Name)
+	}
+	parts := idx.files[path.Dir(chosen.File)]
+
+	frames := make([]*Recipe, anim.Frames)
+	for i := range frames {
+		frame := *recipe
+		frame.Layers = append([]RecipeLayer(nil), recipe.Layers...)
+		index := (chosen.Index + i) % len(parts)
+		frame.Layers[layer].File = parts[index]
+		frame.Layers[layer].Index = index
+		frames[i] = &frame
+	}
+	return frames, nil
+}
+
+// RenderAnimation renders the frames of anim for recipe into one image of
+// stacked pages, the layout libvips encodes animated WebP and GIF from.
+func (g *Generator) RenderAnimation(ctx context.Context, recipe *Recipe, anim Animation) (*Avatar, error) {
+	if err := anim.Validate(); err != nil {
+		return nil, err
+	}
+	recipes, err := g.FrameRecipes(recipe, anim)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]*vips.ImageRef, 0, len(recipes))
+	var skipped []SkippedLayer
+	for _, r := range recipes {
+		avatar, err := g.Render(ctx, r)
+		if err != nil {
+			for _, frame := range frames {
+				frame.Close()
+			}
+			return nil, err
+		}
+		frames = append(frames, avatar.Image)
+		skipped = append(skipped, avatar.Skipped...)
+	}
+
+	img := frames[0]
+	pageHeight := img.Height()
+	err = img.ArrayJoin(frames[1:], 1)
+	for _, frame := range frames[1:] {
+		frame.Close()
+	}
+	if err != nil {
+		img.Close()
+		return nil, fmt.Errorf("failed to join frames: %v", err)
+	}
+	if err := img.SetPageHeight(pageHeight); err != nil {
+		img.Close()
+		return nil, fmt.Errorf("failed to set page height: %v", err)
+	}
+	delays := make([]int, len(recipes))
+	for i := range delays {
+		delays[i] = int(anim.Delay / time.Millisecond)
+	}
+	if err := img.SetPageDelay(delays); err != nil {
+		img.Close()
+		return nil, fmt.Errorf("failed to set frame delay: %v", err)
+	}
+	img.SetInt("loop", 0)
+
+	return &Avatar{Image: img, Recipe: recipe, Skipped: skipped}, nil
+}
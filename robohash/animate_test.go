@@ -0,0 +1,99 @@
+package robohash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFrameRecipes(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	recipe, err := g.Resolve(context.Background(), Request{Text: "animated", Set: Set5})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	frames, err := g.FrameRecipes(recipe, Animation{Layer: "eyes", Frames: 3})
+	if err != nil {
+		t.Fatalf("FrameRecipes() failed: %v", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("FrameRecipes() returned %d frames, want 3", len(frames))
+	}
+
+	for i, frame := range frames {
+		for j, layer := range frame.Layers {
+			original := recipe.Layers[j]
+			if layer.Name != "eyes" {
+				if layer != original {
+					t.Errorf("frame %d changed layer %s: %+v, want %+v", i, layer.Name, layer, original)
+				}
+				continue
+			}
+			if want := (original.Index + i) % original.Count; layer.Index != want {
+				t.Errorf("frame %d shows eyes part %d, want %d", i, layer.Index, want)
+			}
+		}
+	}
+	if frames[0].Layers[1].File == frames[1].Layers[1].File {
+		t.Error("the first two frames show the same eyes")
+	}
+}
+
+func TestFrameRecipesInvalid(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	recipe, err := g.Resolve(context.Background(), Request{Text: "animated", Set: Set1})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	for _, anim := range []Animation{
+		{Layer: "wave"},
+		{Layer: "eyes", Frames: 1},
+		{Layer: "eyes", Frames: MaxFrames + 1},
+		{Layer: "eyes", Delay: time.Millisecond},
+		{Layer: "eyes", Delay: time.Minute},
+	} {
+		if _, err := g.FrameRecipes(recipe, anim); !errors.Is(err, ErrInvalidAnimation) {
+			t.Errorf("FrameRecipes(%+v) = %v, want ErrInvalidAnimation", anim, err)
+		}
+	}
+}
+
+func TestRenderAnimation(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	recipe, err := g.Resolve(context.Background(), Request{Text: "animated", Set: Set3, Size: "64x64"})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	avatar, err := g.RenderAnimation(context.Background(), recipe, Animation{Layer: "wave", Frames: 4})
+	if err != nil {
+		t.Fatalf("RenderAnimation() failed: %v", err)
+	}
+	defer avatar.Close()
+
+	if avatar.Image.PageHeight() != 64 || avatar.Image.Height() != 4*64 {
+		t.Errorf("animation is %dx%d with pages of %d, want 4 pages of 64", avatar.Image.Width(), avatar.Image.Height(), avatar.Image.PageHeight())
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(avatar.Image, FormatGIF, DefaultEncodeOptions(FormatGIF), &buf); err != nil {
+		t.Fatalf("Encode(gif) failed: %v", err)
+	}
+	// Every frame starts with a graphic control extension.
+	if n := bytes.Count(buf.Bytes(), []byte{0x21, 0xf9, 0x04}); n != 4 {
+		t.Errorf("animated GIF has %d frames, want 4", n)
+	}
+}
@@ -0,0 +1,44 @@
+package robohash
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// SetAssetSource makes RoboHash and the package level helpers read sets
+// and backgrounds from fsys instead of the embedded assets. The tree layout
+// must match assets/.
+func SetAssetSource(fsys fs.FS) error {
+	g, err := NewGenerator(WithAssets(fsys), WithCache(NewImageCache(envCacheSize())))
+	if err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defaultGen = g
+	defaultMu.Unlock()
+	return nil
+}
+
+// SetAssetsDir reads assets from an on-disk directory instead of the
+// embedded copy.
+func SetAssetsDir(dir string) error {
+	return SetAssetSource(os.DirFS(dir))
+}
+
+// loadAsset opens an asset file and returns the image with the size of the
+// file buffer it keeps.
+func (g *Generator) loadAsset(name string) (*vips.ImageRef, int, error) {
+	buf, err := fs.ReadFile(g.assets, name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s: %v", ErrAssetMissing, name, err)
+	}
+	img, err := vips.NewImageFromBuffer(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, len(buf), nil
+}
@@ -0,0 +1,218 @@
+package robohash
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// ImageCache is an LRU of decoded asset images bounded by the number of
+// bytes their pixels occupy. It holds both the originally decoded parts and
+// copies already resized and normalized for a given target size.
+type ImageCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// CacheStats is a snapshot of ImageCache counters.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+}
+
+type cacheEntry struct {
+	key  string
+	img  *vips.ImageRef
+	size int64
+}
+
+// NewImageCache creates a cache holding at most maxBytes of decoded pixels.
+// A cache with maxBytes <= 0 stores nothing.
+func NewImageCache(maxBytes int64) *ImageCache {
+	return &ImageCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get returns a private reference to the cached image, which the caller
+// must close. The cached image itself is never handed out, so eviction
+// cannot invalidate an image that is still being composited.
+func (c *ImageCache) get(key string) (*vips.ImageRef, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	img, err := el.Value.(*cacheEntry).img.Copy()
+	if err != nil {
+		c.misses++
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.hits++
+	return img, true
+}
+
+// add stores img under key, taking ownership of it. encoded is the size of
+// the encoded buffer img keeps and decodes from on every use; it is charged
+// on top of the pixels.
+func (c *ImageCache) add(key string, img *vips.ImageRef, encoded int) {
+	size := int64(img.Width())*int64(img.Height())*int64(img.Bands()) + int64(encoded)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size > c.maxBytes {
+		img.Close()
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, img: img, size: size})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *ImageCache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+	entry.img.Close()
+}
+
+// Stats returns the current cache counters.
+func (c *ImageCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+// Purge drops every cached image.
+func (c *ImageCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func layerCacheKey(name string, width, height int) string {
+	return fmt.Sprintf("%s|%dx%d", name, width, height)
+}
+
+// loadOriginal returns the decoded asset as stored in the asset source.
+func (g *Generator) loadOriginal(name string) (*vips.ImageRef, error) {
+	if img, ok := g.cache.get(name); ok {
+		return img, nil
+	}
+
+	img, size, err := g.loadAsset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := img.Copy()
+	if err == nil {
+		g.cache.add(name, cached, size)
+	}
+	return img, nil
+}
+
+// loadLayer returns the asset resized to width x height and normalized to
+// sRGB with alpha, ready to be composited. Resized layers are cached on
+// their own; at the native size the cached original already is the layer.
+func (g *Generator) loadLayer(name string, width, height int) (*vips.ImageRef, error) {
+	img, err := g.loadOriginal(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if img.Width() == width && img.Height() == height {
+		if err := normalizeImage(img); err != nil {
+			img.Close()
+			return nil, err
+		}
+		return img, nil
+	}
+
+	key := layerCacheKey(name, width, height)
+	if layer, ok := g.cache.get(key); ok {
+		img.Close()
+		return layer, nil
+	}
+
+	scale := float64(width) / float64(img.Width())
+	if err := img.Resize(scale, vips.KernelLanczos3); err != nil {
+		img.Close()
+		return nil, err
+	}
+	if err := normalizeImage(img); err != nil {
+		img.Close()
+		return nil, err
+	}
+
+	layer, size, err := materialize(img)
+	img.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := layer.Copy()
+	if err == nil {
+		g.cache.add(key, cached, size)
+	}
+	return layer, nil
+}
+
+// materialize renders the pending resize pipeline of img into an
+// uncompressed PNG and returns the image reading it back with the PNG size.
+// Cache hits skip the Lanczos pass but still decode that PNG, which is cheap
+// without compression; govips has no vips_image_new_from_memory wrapper to
+// keep the raw pixels instead.
+func materialize(img *vips.ImageRef) (*vips.ImageRef, int, error) {
+	buf, _, err := img.ExportPng(&vips.PngExportParams{Compression: 0})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to materialize image: %v", err)
+	}
+	layer, err := vips.NewImageFromBuffer(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return layer, len(buf), nil
+}
@@ -0,0 +1,100 @@
+package robohash
+
+import (
+	"context"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func newTestImage(t *testing.T, width, height int) *vips.ImageRef {
+	img, err := vips.Black(width, height)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	return img
+}
+
+func TestImageCacheEviction(t *testing.T) {
+	// Black() images have a single band, so each entry costs 10*10 bytes.
+	cache := NewImageCache(250)
+
+	cache.add("a", newTestImage(t, 10, 10), 0)
+	cache.add("b", newTestImage(t, 10, 10), 0)
+
+	if img, ok := cache.get("a"); !ok {
+		t.Fatal("Expected hit for key a")
+	} else {
+		img.Close()
+	}
+
+	cache.add("c", newTestImage(t, 10, 10), 0)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("Expected least recently used key b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		img, ok := cache.get(key)
+		if !ok {
+			t.Errorf("Expected hit for key %s", key)
+			continue
+		}
+		img.Close()
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Bytes != 200 {
+		t.Errorf("Unexpected cache size: %d entries, %d bytes", stats.Entries, stats.Bytes)
+	}
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+
+	cache.Purge()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Cache not empty after Purge: %+v", stats)
+	}
+}
+
+func TestImageCacheOversizedEntry(t *testing.T) {
+	cache := NewImageCache(50)
+	cache.add("big", newTestImage(t, 10, 10), 0)
+
+	if _, ok := cache.get("big"); ok {
+		t.Error("Entry larger than the cache budget should not be stored")
+	}
+}
+
+func TestImageCacheEncodedSize(t *testing.T) {
+	// The encoded buffer an image decodes from counts on top of its pixels.
+	cache := NewImageCache(1000)
+	cache.add("png", newTestImage(t, 10, 10), 50)
+
+	if stats := cache.Stats(); stats.Bytes != 150 {
+		t.Errorf("Cache size = %d bytes, want 150", stats.Bytes)
+	}
+}
+
+func TestLayerCacheHits(t *testing.T) {
+	cache := NewImageCache(DefaultCacheSize)
+	g, err := NewGenerator(WithCache(cache))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		avatar, err := g.Generate(context.Background(), Request{Text: "cache_hits", Set: "set1"})
+		if err != nil {
+			t.Fatalf("Generate() failed: %v", err)
+		}
+		avatar.Close()
+	}
+
+	stats := g.CacheStats()
+	if stats.Hits == 0 {
+		t.Errorf("Expected layer cache hits on second render, got %+v", stats)
+	}
+	if stats.Entries == 0 {
+		t.Error("Expected decoded layers to be cached")
+	}
+}
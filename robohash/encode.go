@@ -0,0 +1,226 @@
+package robohash
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// DefaultQuality is the lossy quality used when EncodeOptions leaves it
+// zero.
+const DefaultQuality = 85
+
+// MaxEffort is the highest encoder effort. WebP stops at 6 and is capped
+// there.
+const MaxEffort = 9
+
+// EncodeOptions are the encoder settings of one image. Each format reads
+// the fields it supports and ignores the rest. Start from
+// DefaultEncodeOptions to keep the defaults of the other fields.
+type EncodeOptions struct {
+	// Quality is the lossy quality, 1 to 100. Zero selects DefaultQuality.
+	Quality int
+	// Lossless switches WebP, AVIF, HEIF and JPEG XL to lossless
+	// compression and TIFF from WebP to LZW compression.
+	Lossless bool
+	// NearLossless preprocesses lossless WebP at Quality.
+	NearLossless bool
+	// Effort trades encoding time for size, 0 to MaxEffort: the AVIF, HEIF,
+	// GIF and JPEG XL effort, WebP reduction effort or PNG and ICO
+	// compression level.
+	Effort int
+	// Palette quantizes PNG and ICO images to at most 2^Bitdepth colours.
+	Palette bool
+	// Bitdepth is the PNG, AVIF or HEIF bit depth or the GIF palette depth,
+	// zero for the encoder default.
+	Bitdepth int
+}
+
+// DefaultEncodeOptions returns the settings the server encodes format with
+// unless asked otherwise.
+func DefaultEncodeOptions(format Format) EncodeOptions {
+	switch format {
+	case FormatAVIF:
+		return EncodeOptions{Quality: DefaultQuality, Effort: 8, Bitdepth: 8}
+	case FormatWebP:
+		return EncodeOptions{Quality: DefaultQuality, Lossless: true, Effort: 4}
+	case FormatJPEG:
+		return EncodeOptions{Quality: DefaultQuality}
+	case FormatGIF:
+		return EncodeOptions{Quality: DefaultQuality, Effort: 7, Bitdepth: 8}
+	case FormatTIFF:
+		return EncodeOptions{Quality: DefaultQuality, Lossless: true}
+	case FormatHEIF:
+		return EncodeOptions{Quality: DefaultQuality, Effort: 5, Bitdepth: 8}
+	case FormatJXL:
+		return EncodeOptions{Quality: DefaultQuality, Effort: 7}
+	default:
+		return EncodeOptions{Quality: DefaultQuality, Effort: 6}
+	}
+}
+
+// Validate checks the options against what format supports.
+func (o EncodeOptions) Validate(format Format) error {
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("%w: quality %d outside 1-100", ErrInvalidEncodeOptions, o.Quality)
+	}
+	if o.Effort < 0 || o.Effort > MaxEffort {
+		return fmt.Errorf("%w: effort %d outside 0-%d", ErrInvalidEncodeOptions, o.Effort, MaxEffort)
+	}
+	if o.Bitdepth != 0 && !validBitdepth(format, o) {
+		return fmt.Errorf("%w: bitdepth %d not supported for %s", ErrInvalidEncodeOptions, o.Bitdepth, format)
+	}
+	return nil
+}
+
+func validBitdepth(format Format, o EncodeOptions) bool {
+	switch format {
+	case FormatPNG:
+		if o.Palette {
+			return o.Bitdepth == 1 || o.Bitdepth == 2 || o.Bitdepth == 4 || o.Bitdepth == 8
+		}
+		return o.Bitdepth == 8 || o.Bitdepth == 16
+	case FormatAVIF, FormatHEIF:
+		return o.Bitdepth == 8 || o.Bitdepth == 10 || o.Bitdepth == 12
+	case FormatGIF:
+		return o.Bitdepth >= 1 && o.Bitdepth <= 8
+	default:
+		return false
+	}
+}
+
+// FormatFromExtension returns the format of a file extension such as
+// ".png", ".JPG" or ".tif".
+func FormatFromExtension(ext string) (Format, error) {
+	if len(ext) < 2 || ext[0] != '.' {
+		return "", fmt.Errorf("%w: extension %q", ErrUnknownFormat, ext)
+	}
+	return ParseFormat(ext)
+}
+
+// ContentType returns the media type of format, or "" for an unknown
+// format.
+func ContentType(format Format) string {
+	switch format {
+	case FormatPNG:
+		return "image/png"
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatWebP:
+		return "image/webp"
+	case FormatAVIF:
+		return "image/avif"
+	case FormatGIF:
+		return "image/gif"
+	case FormatTIFF:
+		return "image/tiff"
+	case FormatHEIF:
+		return "image/heic"
+	case FormatJXL:
+		return "image/jxl"
+	case FormatICO:
+		return "image/x-icon"
+	case FormatSVG:
+		return "image/svg+xml"
+	}
+	return ""
+}
+
+// Encode writes img to w in format. SVG documents are made of the layer
+// files rather than pixels and written by Generator.EncodeSVG instead.
+func Encode(img *vips.ImageRef, format Format, opts EncodeOptions, w io.Writer) error {
+	if err := opts.Validate(format); err != nil {
+		return err
+	}
+	if opts.Quality == 0 {
+		opts.Quality = DefaultQuality
+	}
+
+	var buf []byte
+	var err error
+	switch format {
+	case FormatAVIF:
+		buf, _, err = img.ExportAvif(&vips.AvifExportParams{
+			Quality:  opts.Quality,
+			Effort:   opts.Effort,
+			Lossless: opts.Lossless,
+			Bitdepth: opts.Bitdepth,
+		})
+	case FormatWebP:
+		buf, _, err = img.ExportWebp(&vips.WebpExportParams{
+			Quality:         opts.Quality,
+			Lossless:        opts.Lossless,
+			NearLossless:    opts.NearLossless,
+			ReductionEffort: min(opts.Effort, 6),
+		})
+	case FormatJPEG:
+		buf, _, err = img.ExportJpeg(&vips.JpegExportParams{
+			Quality:        opts.Quality,
+			OptimizeCoding: true,
+			SubsampleMode:  vips.VipsForeignSubsampleAuto,
+		})
+	case FormatPNG:
+		buf, _, err = img.ExportPng(&vips.PngExportParams{
+			Compression: opts.Effort,
+			Quality:     opts.Quality,
+			Palette:     opts.Palette,
+			Bitdepth:    opts.Bitdepth,
+		})
+	case FormatGIF:
+		// The GIF encoder makes pixels below half opacity transparent and
+		// the rest opaque.
+		buf, _, err = img.ExportGIF(&vips.GifExportParams{
+			Effort:   max(opts.Effort, 1),
+			Bitdepth: opts.Bitdepth,
+		})
+	case FormatTIFF:
+		params := &vips.TiffExportParams{
+			Quality:     opts.Quality,
+			Compression: vips.TiffCompressionLzw,
+			Predictor:   vips.TiffPredictorHorizontal,
+		}
+		if !opts.Lossless {
+			// WebP, unlike JPEG compression, keeps the alpha channel.
+			params.Compression = vips.TiffCompressionWebp
+			params.Predictor = vips.TiffPredictorNone
+		}
+		buf, _, err = img.ExportTiff(params)
+	case FormatHEIF:
+		buf, _, err = img.ExportHeif(&vips.HeifExportParams{
+			Quality:  opts.Quality,
+			Effort:   opts.Effort,
+			Lossless: opts.Lossless,
+			Bitdepth: opts.Bitdepth,
+		})
+	case FormatJXL:
+		buf, _, err = img.ExportJxl(&vips.JxlExportParams{
+			Quality:  opts.Quality,
+			Lossless: opts.Lossless,
+			Effort:   max(opts.Effort, 1),
+			Distance: jxlDistance(opts.Quality),
+		})
+	case FormatICO:
+		return encodeICO(img, opts, w)
+	case FormatSVG:
+		return fmt.Errorf("%w: svg is written from a recipe by Generator.EncodeSVG", ErrUnknownFormat)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return fmt.Errorf("encoding %s image: %w", format, err)
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// jxlDistance converts a quality to the butteraugli distance the JPEG XL
+// encoder works with, using the libvips mapping.
+func jxlDistance(quality int) float64 {
+	q := float64(quality)
+	if q >= 30 {
+		return 0.1 + (100-q)*0.09
+	}
+	return 53.0/3000.0*q*q - 23.0/20.0*q + 25
+}
@@ -0,0 +1,156 @@
+package robohash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	avatar, err := g.Generate(context.Background(), Request{Text: "encode", Set: Set1, Size: "64x64"})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	defer avatar.Close()
+
+	magic := map[Format]func([]byte) bool{
+		FormatPNG:  func(b []byte) bool { return bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) },
+		FormatJPEG: func(b []byte) bool { return bytes.HasPrefix(b, []byte{0xff, 0xd8, 0xff}) },
+		FormatWebP: func(b []byte) bool { return len(b) > 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP" },
+		FormatAVIF: func(b []byte) bool { return len(b) > 12 && string(b[4:8]) == "ftyp" && string(b[8:12]) == "avif" },
+		FormatGIF:  func(b []byte) bool { return bytes.HasPrefix(b, []byte("GIF89a")) },
+		FormatTIFF: func(b []byte) bool {
+			return bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*"))
+		},
+		FormatHEIF: func(b []byte) bool {
+			return len(b) > 12 && string(b[4:8]) == "ftyp" && (string(b[8:12]) == "heic" || string(b[8:12]) == "mif1")
+		},
+		FormatICO: func(b []byte) bool { return bytes.HasPrefix(b, []byte{0, 0, 1, 0}) },
+		FormatJXL: func(b []byte) bool {
+			return bytes.HasPrefix(b, []byte{0xff, 0x0a}) || bytes.HasPrefix(b, []byte("\x00\x00\x00\x0cJXL "))
+		},
+	}
+
+	for _, format := range Formats {
+		if format == FormatSVG {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := Encode(avatar.Image, format, DefaultEncodeOptions(format), &buf); err != nil {
+			t.Errorf("Encode(%s) failed: %v", format, err)
+			continue
+		}
+		if !magic[format](buf.Bytes()) {
+			t.Errorf("Encode(%s) wrote no %s image: % x", format, format, buf.Bytes()[:min(buf.Len(), 16)])
+		}
+	}
+
+	var lossy, lossless bytes.Buffer
+	if err := Encode(avatar.Image, FormatWebP, EncodeOptions{Quality: 30}, &lossy); err != nil {
+		t.Fatalf("Encode(webp, quality 30) failed: %v", err)
+	}
+	if err := Encode(avatar.Image, FormatWebP, EncodeOptions{Lossless: true}, &lossless); err != nil {
+		t.Fatalf("Encode(webp, lossless) failed: %v", err)
+	}
+	if lossy.Len() >= lossless.Len() {
+		t.Errorf("lossy WebP (%d bytes) is not smaller than lossless (%d bytes)", lossy.Len(), lossless.Len())
+	}
+}
+
+func TestEncodeGIFTransparency(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	avatar, err := g.Generate(context.Background(), Request{Text: "gif", Set: Set1, Size: "64x64"})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	defer avatar.Close()
+
+	var buf bytes.Buffer
+	if err := Encode(avatar.Image, FormatGIF, DefaultEncodeOptions(FormatGIF), &buf); err != nil {
+		t.Fatalf("Encode(gif) failed: %v", err)
+	}
+
+	// A graphic control extension with the transparent colour flag set.
+	gce := bytes.Index(buf.Bytes(), []byte{0x21, 0xf9, 0x04})
+	if gce < 0 || buf.Bytes()[gce+3]&1 == 0 {
+		t.Error("GIF of an avatar without background has no transparent colour")
+	}
+}
+
+func TestEncodeOptionsValidate(t *testing.T) {
+	valid := []struct {
+		format Format
+		opts   EncodeOptions
+	}{
+		{FormatPNG, EncodeOptions{}},
+		{FormatPNG, EncodeOptions{Palette: true, Bitdepth: 2}},
+		{FormatPNG, EncodeOptions{Bitdepth: 16, Effort: 9}},
+		{FormatAVIF, EncodeOptions{Quality: 100, Bitdepth: 12}},
+		{FormatJPEG, EncodeOptions{Quality: 1}},
+		{FormatGIF, EncodeOptions{Bitdepth: 1}},
+		{FormatHEIF, EncodeOptions{Bitdepth: 10}},
+		{FormatJXL, EncodeOptions{Lossless: true}},
+	}
+	for _, tc := range valid {
+		if err := tc.opts.Validate(tc.format); err != nil {
+			t.Errorf("%+v.Validate(%s) failed: %v", tc.opts, tc.format, err)
+		}
+	}
+
+	invalid := []struct {
+		format Format
+		opts   EncodeOptions
+	}{
+		{FormatPNG, EncodeOptions{Quality: 101}},
+		{FormatPNG, EncodeOptions{Effort: 10}},
+		{FormatPNG, EncodeOptions{Bitdepth: 4}},
+		{FormatAVIF, EncodeOptions{Bitdepth: 16}},
+		{FormatWebP, EncodeOptions{Bitdepth: 8}},
+		{FormatGIF, EncodeOptions{Bitdepth: 16}},
+		{FormatTIFF, EncodeOptions{Bitdepth: 8}},
+	}
+	for _, tc := range invalid {
+		if err := tc.opts.Validate(tc.format); !errors.Is(err, ErrInvalidEncodeOptions) {
+			t.Errorf("%+v.Validate(%s) = %v, want ErrInvalidEncodeOptions", tc.opts, tc.format, err)
+		}
+	}
+}
+
+func TestFormatFromExtension(t *testing.T) {
+	for ext, want := range map[string]Format{
+		".png":  FormatPNG,
+		".JPG":  FormatJPEG,
+		".jpeg": FormatJPEG,
+		".webp": FormatWebP,
+		".avif": FormatAVIF,
+		".gif":  FormatGIF,
+		".tif":  FormatTIFF,
+		".tiff": FormatTIFF,
+		".heic": FormatHEIF,
+		".heif": FormatHEIF,
+		".jxl":  FormatJXL,
+		".ico":  FormatICO,
+		".svg":  FormatSVG,
+	} {
+		if got, err := FormatFromExtension(ext); err != nil || got != want {
+			t.Errorf("FormatFromExtension(%q) = %q, %v, want %q", ext, got, err, want)
+		}
+		if ContentType(want) == "" {
+			t.Errorf("ContentType(%s) is empty", want)
+		}
+	}
+
+	for _, ext := range []string{"", ".", "png", ".bmp"} {
+		if _, err := FormatFromExtension(ext); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("FormatFromExtension(%q) = %v, want ErrUnknownFormat", ext, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package robohash
+
+import "errors"
+
+// Errors returned by the parse helpers, Resolve and Render. They are
+// wrapped with details, so test for them with errors.Is.
+var (
+	// ErrUnknownSet reports a set missing from the asset source.
+	ErrUnknownSet = errors.New("unknown set")
+	// ErrUnknownBackground reports a background set missing from the asset
+	// source.
+	ErrUnknownBackground = errors.New("unknown background set")
+	// ErrInvalidSize reports a malformed size or one beyond the size limit.
+	ErrInvalidSize = errors.New("invalid size")
+	// ErrUnknownFormat reports an unsupported output format.
+	ErrUnknownFormat = errors.New("unknown format")
+	// ErrInvalidEncodeOptions reports encoder settings out of range or not
+	// supported by the format.
+	ErrInvalidEncodeOptions = errors.New("invalid encode options")
+	// ErrInvalidAnimation reports an animated layer missing from the set or
+	// a frame count or delay out of range.
+	ErrInvalidAnimation = errors.New("invalid animation")
+	// ErrAssetMissing reports an asset the index lists but that cannot be
+	// read, or an asset source without any set or background to pick from.
+	ErrAssetMissing = errors.New("asset missing")
+)
@@ -0,0 +1,224 @@
+package robohash
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/terem42/robohash/assets"
+)
+
+const (
+	// DefaultCacheSize is the decoded asset cache budget of a Generator
+	// created without WithCache.
+	DefaultCacheSize = 100 * 1024 * 1024
+	// DefaultMaxSize bounds the requested output width and height.
+	DefaultMaxSize = 4096
+	// AlgorithmVersion identifies how avatars are derived from a request.
+	// It changes whenever the same request and assets render differently,
+	// so it can be folded into cache validators.
+	AlgorithmVersion = "1"
+)
+
+// Generator renders avatars. It is safe for concurrent use; all state that
+// depends on a request lives in the Request and Avatar values.
+type Generator struct {
+	assets     fs.FS
+	cache      *ImageCache
+	defaultSet Set
+	maxWidth   int
+	maxHeight  int
+	logger     *log.Logger
+	strict     bool
+
+	indexMu sync.Mutex
+	index   *assetIndex
+}
+
+// Option configures a Generator.
+type Option func(*Generator)
+
+// WithAssets reads sets and backgrounds from fsys instead of the embedded
+// assets. The tree layout must match assets/.
+func WithAssets(fsys fs.FS) Option {
+	return func(g *Generator) {
+		g.assets = fsys
+	}
+}
+
+// WithAssetsDir reads sets and backgrounds from an on-disk directory.
+func WithAssetsDir(dir string) Option {
+	return WithAssets(os.DirFS(dir))
+}
+
+// WithCache sets the decoded asset cache. Cache keys are asset paths, so a
+// cache must not be shared by generators reading different asset sources.
+func WithCache(cache *ImageCache) Option {
+	return func(g *Generator) {
+		g.cache = cache
+	}
+}
+
+// WithDefaultSet sets the set used when a request does not name one.
+// NewGenerator fails with ErrUnknownSet when the asset source lacks it.
+func WithDefaultSet(set Set) Option {
+	return func(g *Generator) {
+		g.defaultSet = set
+	}
+}
+
+// WithMaxSize limits the output dimensions a request may ask for.
+func WithMaxSize(width, height int) Option {
+	return func(g *Generator) {
+		g.maxWidth = width
+		g.maxHeight = height
+	}
+}
+
+// WithLogger sets where skipped parts and sets are reported.
+func WithLogger(logger *log.Logger) Option {
+	return func(g *Generator) {
+		g.logger = logger
+	}
+}
+
+// WithStrict makes a layer directory without parts, or a part that cannot
+// be decoded or composited, fail the request instead of being skipped.
+func WithStrict(strict bool) Option {
+	return func(g *Generator) {
+		g.strict = strict
+	}
+}
+
+// NewGenerator creates a Generator and indexes its asset source.
+func NewGenerator(opts ...Option) (*Generator, error) {
+	startVips()
+
+	g := &Generator{
+		assets:    assets.FS,
+		maxWidth:  DefaultMaxSize,
+		maxHeight: DefaultMaxSize,
+		logger:    log.Default(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.cache == nil {
+		g.cache = NewImageCache(DefaultCacheSize)
+	}
+
+	if err := g.RebuildIndex(); err != nil {
+		return nil, err
+	}
+	// A configured default set must exist; the built-in one is only
+	// checked per request, so custom trees without set1 keep working.
+	switch g.defaultSet {
+	case "":
+		g.defaultSet = Set1
+	case SetAny:
+	default:
+		if _, ok := g.index.manifests[string(g.defaultSet)]; !ok {
+			return nil, fmt.Errorf("%w: default set %s", ErrUnknownSet, g.defaultSet)
+		}
+	}
+	return g, nil
+}
+
+// Request describes the avatar to generate.
+type Request struct {
+	Text  string
+	Set   Set
+	Size  string
+	BGSet BackgroundSet
+}
+
+// Avatar is a generated image together with the recipe it was rendered
+// from.
+type Avatar struct {
+	Image  *vips.ImageRef
+	Recipe *Recipe
+	// Skipped lists the layers left out of Image. It is always empty for a
+	// strict Generator.
+	Skipped []SkippedLayer
+}
+
+// SkippedLayer is a recipe layer that could not be rendered.
+type SkippedLayer struct {
+	Name string
+	File string
+	Err  error
+}
+
+// Close releases the avatar image.
+func (a *Avatar) Close() {
+	a.Image.Close()
+}
+
+// Generate resolves and renders the avatar for req.
+func (g *Generator) Generate(ctx context.Context, req Request) (*Avatar, error) {
+	recipe, err := g.Resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return g.Render(ctx, recipe)
+}
+
+// CacheStats reports hit, miss and eviction counters of the decoded asset
+// cache.
+func (g *Generator) CacheStats() CacheStats {
+	return g.cache.Stats()
+}
+
+var (
+	vipsOnce sync.Once
+
+	defaultMu  sync.Mutex
+	defaultGen *Generator
+)
+
+func startVips() {
+	vipsOnce.Do(func() {
+		vips.Startup(&vips.Config{
+			ConcurrencyLevel: 0,
+			MaxCacheFiles:    300,
+			MaxCacheMem:      50 * 1024 * 1024, // 50MB initial cache
+			MaxCacheSize:     100,
+			ReportLeaks:      false,
+			CacheTrace:       false,
+			CollectStats:     false,
+		})
+		vips.LoggingSettings(nil, vips.LogLevelWarning)
+	})
+}
+
+// defaultGenerator returns the Generator behind RoboHash and the package
+// level helpers. Its decoded asset cache is sized in megabytes by
+// ROBOHASH_IMG_CACHE_SIZE.
+func defaultGenerator() (*Generator, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultGen == nil {
+		g, err := NewGenerator(WithCache(NewImageCache(envCacheSize())))
+		if err != nil {
+			return nil, err
+		}
+		defaultGen = g
+	}
+	return defaultGen, nil
+}
+
+func envCacheSize() int64 {
+	cacheSize := 100
+	if sizeStr := os.Getenv("ROBOHASH_IMG_CACHE_SIZE"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			cacheSize = size
+		}
+	}
+	return int64(cacheSize) * 1024 * 1024
+}
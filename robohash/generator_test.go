@@ -0,0 +1,144 @@
+package robohash
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGenerateDoesNotMutateRequest(t *testing.T) {
+	robo := NewRoboHash("mutation_test", "any")
+	robo.BGSet = "any"
+
+	img, err := robo.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	img.Close()
+
+	if robo.Set != "any" || robo.BGSet != "any" {
+		t.Errorf("Generate() modified the receiver: set=%s bgset=%s", robo.Set, robo.BGSet)
+	}
+}
+
+func TestGeneratorConcurrentUse(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	req := Request{Text: "concurrent", Set: "any", Size: "128x128", BGSet: "any"}
+	first, err := g.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	defer first.Close()
+
+	expected, _, err := first.Image.ExportPng(nil)
+	if err != nil {
+		t.Fatalf("Failed to export image: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			avatar, err := g.Generate(context.Background(), req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer avatar.Close()
+
+			if avatar.Recipe.Set != first.Recipe.Set || avatar.Recipe.BGSet != first.Recipe.BGSet {
+				errs <- errors.New("resolved sets differ between goroutines")
+				return
+			}
+			buf, _, err := avatar.Image.ExportPng(nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if md5Hash(buf) != md5Hash(expected) {
+				errs <- errors.New("images differ between goroutines")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGeneratorOptions(t *testing.T) {
+	g, err := NewGenerator(WithDefaultSet("set2"), WithMaxSize(200, 200))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	avatar, err := g.Generate(context.Background(), Request{Text: "options"})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if avatar.Recipe.Set != "set2" {
+		t.Errorf("Default set = %s, want set2", avatar.Recipe.Set)
+	}
+	avatar.Close()
+
+	if _, err := g.Generate(context.Background(), Request{Text: "options", Size: "400x400"}); err == nil {
+		t.Error("Expected error for size above the limit")
+	}
+
+	if _, err := NewGenerator(WithDefaultSet("set9")); !errors.Is(err, ErrUnknownSet) {
+		t.Errorf("NewGenerator(WithDefaultSet(set9)) error = %v, want ErrUnknownSet", err)
+	}
+}
+
+func TestGenerateCanceledContext(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := g.Generate(ctx, Request{Text: "canceled"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Generate() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	assets := fstest.MapFS{
+		"acme/000#01Head/1.png": {Data: testPNG(t, 32, 32)},
+		"acme/001#02Eyes/1.png": {Data: []byte("not a png")},
+	}
+
+	strict, err := NewGenerator(WithAssets(assets), WithStrict(true))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	if _, err := strict.Generate(context.Background(), Request{Text: "strict", Set: "acme"}); err == nil {
+		t.Error("Strict Generate() rendered an undecodable part")
+	}
+
+	lenient, err := NewGenerator(WithAssets(assets))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	avatar, err := lenient.Generate(context.Background(), Request{Text: "strict", Set: "acme"})
+	if err != nil {
+		t.Fatalf("Lenient Generate() failed: %v", err)
+	}
+	defer avatar.Close()
+
+	if len(avatar.Skipped) != 1 || avatar.Skipped[0].Name != "eyes" || avatar.Skipped[0].File != "acme/001#02Eyes/1.png" {
+		t.Errorf("Skipped = %+v, want the Eyes layer", avatar.Skipped)
+	}
+}
@@ -0,0 +1,64 @@
+package robohash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// IconSizes are the square sizes stored in an ICO file, smallest first.
+var IconSizes = []int{16, 32, 48, 64}
+
+// encodeICO scales img to each of IconSizes and writes them as PNG images
+// of one ICO file.
+func encodeICO(img *vips.ImageRef, opts EncodeOptions, w io.Writer) error {
+	images := make([][]byte, len(IconSizes))
+	for i, size := range IconSizes {
+		icon, err := img.Copy()
+		if err != nil {
+			return err
+		}
+		err = icon.ResizeWithVScale(float64(size)/float64(icon.Width()), float64(size)/float64(icon.Height()), vips.KernelLanczos3)
+		if err == nil {
+			images[i], _, err = icon.ExportPng(&vips.PngExportParams{
+				Compression: opts.Effort,
+				Quality:     opts.Quality,
+				Palette:     opts.Palette,
+			})
+		}
+		icon.Close()
+		if err != nil {
+			return fmt.Errorf("encoding %dx%d icon: %w", size, size, err)
+		}
+	}
+	return writeICO(w, IconSizes, images)
+}
+
+// writeICO writes an ICO file holding one PNG image of each size.
+func writeICO(w io.Writer, sizes []int, images [][]byte) error {
+	const headerSize, entrySize = 6, 16
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(images))})
+
+	offset := headerSize + entrySize*len(images)
+	for i, data := range images {
+		// A width or height of 0 stands for 256 pixels.
+		dim := uint8(sizes[i] % 256)
+		binary.Write(&buf, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(data)), uint32(offset)})
+		offset += len(data)
+	}
+	for _, data := range images {
+		buf.Write(data)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,65 @@
+package robohash
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteICO(t *testing.T) {
+	images := [][]byte{[]byte("small"), []byte("larger image")}
+
+	var buf bytes.Buffer
+	if err := writeICO(&buf, []int{16, 256}, images); err != nil {
+		t.Fatalf("writeICO() failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.Equal(data[:6], []byte{0, 0, 1, 0, 2, 0}) {
+		t.Fatalf("ICO header = % x", data[:6])
+	}
+	for i, want := range []struct {
+		dim  uint8
+		data []byte
+	}{{16, images[0]}, {0, images[1]}} {
+		entry := data[6+16*i : 6+16*(i+1)]
+		size := binary.LittleEndian.Uint32(entry[8:12])
+		offset := binary.LittleEndian.Uint32(entry[12:16])
+		if entry[0] != want.dim || entry[1] != want.dim {
+			t.Errorf("entry %d is %dx%d, want %d", i, entry[0], entry[1], want.dim)
+		}
+		if got := data[offset : offset+size]; !bytes.Equal(got, want.data) {
+			t.Errorf("entry %d points at %q, want %q", i, got, want.data)
+		}
+	}
+}
+
+func TestEncodeICO(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	avatar, err := g.Generate(context.Background(), Request{Text: "favicon", Set: Set1, Size: "64x64"})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	defer avatar.Close()
+
+	var buf bytes.Buffer
+	if err := Encode(avatar.Image, FormatICO, DefaultEncodeOptions(FormatICO), &buf); err != nil {
+		t.Fatalf("Encode(ico) failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	if count := int(binary.LittleEndian.Uint16(data[4:6])); count != len(IconSizes) {
+		t.Fatalf("ICO holds %d images, want %d", count, len(IconSizes))
+	}
+	for i, size := range IconSizes {
+		entry := data[6+16*i : 6+16*(i+1)]
+		offset := binary.LittleEndian.Uint32(entry[12:16])
+		if int(entry[0]) != size || !bytes.HasPrefix(data[offset:], []byte("\x89PNG")) {
+			t.Errorf("entry %d: width %d, data % x, want a %dx%d PNG", i, entry[0], data[offset:offset+4], size, size)
+		}
+	}
+}
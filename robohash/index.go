@@ -0,0 +1,200 @@
+package robohash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facette/natsort"
+)
+
+// assetIndex is an immutable listing of the asset source, built once so
+// that part selection never touches the filesystem.
+type assetIndex struct {
+	// sets lists the usable sets in name order.
+	sets []string
+	// manifests describes every usable set by name.
+	manifests map[string]*SetManifest
+	// backgrounds lists the background set directories in name order.
+	backgrounds []string
+	// subdirs maps a directory to its child directory names in name order.
+	subdirs map[string][]string
+	// files maps a directory to the PNG files it contains, natural sorted.
+	files map[string][]string
+	// skipped explains why set directories were left out.
+	skipped []error
+	// modTime is the newest modification time of the files, zero when the
+	// source does not record one (like the embedded assets).
+	modTime time.Time
+	// fingerprint is the hex SHA-256 of the paths and contents of the parts
+	// and manifests.
+	fingerprint string
+}
+
+func buildAssetIndex(fsys fs.FS) (*assetIndex, error) {
+	idx := &assetIndex{
+		manifests: make(map[string]*SetManifest),
+		subdirs:   make(map[string][]string),
+		files:     make(map[string][]string),
+	}
+
+	// WalkDir visits files in lexical order, so the fingerprint only
+	// depends on the tree.
+	fingerprint := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+
+		dir := path.Dir(p)
+		if d.IsDir() {
+			idx.subdirs[dir] = append(idx.subdirs[dir], d.Name())
+			return nil
+		}
+		// Only parts and manifests make up the assets; top-level files (like
+		// the embed.go of the embedded tree) and stray notes do not.
+		isPart := strings.HasSuffix(d.Name(), ".png")
+		if dir == "." || !isPart && d.Name() != "manifest.json" {
+			return nil
+		}
+		if isPart {
+			idx.files[dir] = append(idx.files[dir], p)
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(idx.modTime) {
+			idx.modTime = info.ModTime()
+		}
+		return hashFile(fingerprint, fsys, p)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index assets: %v", err)
+	}
+
+	for dir := range idx.subdirs {
+		sort.Strings(idx.subdirs[dir])
+	}
+	for dir := range idx.files {
+		natsort.Sort(idx.files[dir])
+	}
+
+	for _, name := range idx.subdirs["."] {
+		if name == "backgrounds" {
+			continue
+		}
+
+		m, err := readManifest(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			m, err = inferManifest(fsys, idx, name)
+			if m == nil && err == nil {
+				continue
+			}
+		}
+		if err == nil {
+			err = m.validate(idx)
+		}
+		if err != nil {
+			idx.skipped = append(idx.skipped, fmt.Errorf("skipping set %s: %v", name, err))
+			continue
+		}
+
+		idx.sets = append(idx.sets, name)
+		idx.manifests[name] = m
+	}
+	idx.backgrounds = idx.subdirs["backgrounds"]
+	idx.fingerprint = hex.EncodeToString(fingerprint.Sum(nil))
+
+	return idx, nil
+}
+
+// hashFile adds the path, size and content of one file to h.
+func hashFile(h hash.Hash, fsys fs.FS, name string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s\x00%d\x00", name, len(data))
+	h.Write(data)
+	return nil
+}
+
+// loadIndex returns the index of the generator asset source.
+func (g *Generator) loadIndex() (*assetIndex, error) {
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	if g.index == nil {
+		return nil, fmt.Errorf("asset index is not built")
+	}
+	return g.index, nil
+}
+
+// RebuildIndex re-reads the asset source. It is only needed when the files
+// behind an on-disk asset directory change while the process is running.
+func (g *Generator) RebuildIndex() error {
+	idx, err := buildAssetIndex(g.assets)
+	if err != nil {
+		return err
+	}
+	for _, err := range idx.skipped {
+		g.logger.Print(err)
+	}
+
+	g.indexMu.Lock()
+	g.index = idx
+	g.indexMu.Unlock()
+
+	g.cache.Purge()
+	return nil
+}
+
+// AssetsModTime returns the newest modification time of the asset files as
+// of the last index build, or the zero time when the asset source does not
+// record modification times, as with the embedded assets.
+func (g *Generator) AssetsModTime() time.Time {
+	idx, err := g.loadIndex()
+	if err != nil {
+		return time.Time{}
+	}
+	return idx.modTime
+}
+
+// AssetFingerprint returns the hex SHA-256 of the paths and contents of the
+// part images and set manifests in the asset source as of the last index
+// build. It changes with any
+// set or background, so it can version caches of rendered avatars.
+func (g *Generator) AssetFingerprint() string {
+	idx, err := g.loadIndex()
+	if err != nil {
+		return ""
+	}
+	return idx.fingerprint
+}
+
+// RebuildIndex re-reads the asset source of the default generator.
+func RebuildIndex() error {
+	g, err := defaultGenerator()
+	if err != nil {
+		return err
+	}
+	return g.RebuildIndex()
+}
+
+// partCount returns how many PNG parts the directory holds.
+func (idx *assetIndex) partCount(dir string) int {
+	return len(idx.files[dir])
+}
+
+// hasBackground reports whether the asset source has the background set.
+func (idx *assetIndex) hasBackground(name string) bool {
+	return slices.Contains(idx.backgrounds, name)
+}
@@ -0,0 +1,84 @@
+package robohash
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestBuildAssetIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		"set1/manifest.json": {Data: []byte(`{"width": 300, "height": 300, "color_slot": 0,
+			"layers": [{"name": "mouth", "dir": "000#Mouth", "slot": 4}]}`)},
+		"set2/manifest.json": {Data: []byte(`{"width": 350, "height": 350,
+			"layers": [{"name": "body", "dir": "000#Body", "slot": 4}]}`)},
+		"set1/red/000#Mouth/10.png":      {},
+		"set1/red/000#Mouth/2.png":       {ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		"set1/blue/000#Mouth/1.png":      {},
+		"set2/000#Body/1.png":            {},
+		"set2/000#Body/notes.txt":        {},
+		"backgrounds/bg2/1.png":          {},
+		"backgrounds/bg1/1.png":          {},
+		"misc/readme.png":                {},
+		"set3/000#Empty/.keep":           {},
+		"backgrounds/bg1/nested/ignored": {},
+	}
+
+	idx, err := buildAssetIndex(fsys)
+	if err != nil {
+		t.Fatalf("buildAssetIndex() failed: %v", err)
+	}
+
+	// set3 has no manifest and is left out.
+	if want := []string{"set1", "set2"}; !reflect.DeepEqual(idx.sets, want) {
+		t.Errorf("sets = %v, want %v", idx.sets, want)
+	}
+	if want := []string{"bg1", "bg2"}; !reflect.DeepEqual(idx.backgrounds, want) {
+		t.Errorf("backgrounds = %v, want %v", idx.backgrounds, want)
+	}
+	if want := []string{"blue", "red"}; !reflect.DeepEqual(idx.subdirs["set1"], want) {
+		t.Errorf("set1 colors = %v, want %v", idx.subdirs["set1"], want)
+	}
+
+	want := []string{"set1/red/000#Mouth/2.png", "set1/red/000#Mouth/10.png"}
+	if got := idx.files["set1/red/000#Mouth"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want natural order %v", got, want)
+	}
+	if n := idx.partCount("set2/000#Body"); n != 1 {
+		t.Errorf("partCount(set2/000#Body) = %d, want 1", n)
+	}
+	if n := idx.partCount("set3/000#Empty"); n != 0 {
+		t.Errorf("partCount(set3/000#Empty) = %d, want 0", n)
+	}
+	if want := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC); !idx.modTime.Equal(want) {
+		t.Errorf("modTime = %v, want %v", idx.modTime, want)
+	}
+
+	same, err := buildAssetIndex(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.fingerprint) != 64 || same.fingerprint != idx.fingerprint {
+		t.Errorf("fingerprint = %q, then %q", idx.fingerprint, same.fingerprint)
+	}
+	fsys["backgrounds/bg1/1.png"] = &fstest.MapFile{Data: []byte("changed")}
+	changed, err := buildAssetIndex(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.fingerprint == idx.fingerprint {
+		t.Error("fingerprint ignores a changed background")
+	}
+
+	// Files that are not assets leave the fingerprint alone.
+	fsys["embed.go"] = &fstest.MapFile{Data: []byte("package assets")}
+	fsys["set2/000#Body/notes.txt"] = &fstest.MapFile{Data: []byte("changed")}
+	unrelated, err := buildAssetIndex(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unrelated.fingerprint != changed.fingerprint {
+		t.Error("fingerprint depends on files that are not assets")
+	}
+}
@@ -0,0 +1,238 @@
+package robohash
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// manifestFile is the name of the set description inside a set directory.
+const manifestFile = "manifest.json"
+
+// hashSlots is the number of hash parts a layer or colour can be keyed by.
+const hashSlots = 22
+
+// firstLayerSlot is the hash part used by the first layer of a set; the
+// ones before it pick the set, colour and background set.
+const firstLayerSlot = 4
+
+// layerDirPattern matches layer directories such as "003#01Body" or
+// "000#Mouth": a sequence number, '#', an optional z-order and a name.
+var layerDirPattern = regexp.MustCompile(`^\d+#(\d*)(.+)$`)
+
+// SetManifest describes how an avatar set is assembled from its directories.
+type SetManifest struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// ColorSlot, when set, means the set has one directory level of colour
+	// variants (like set1/blue) picked by this hash slot.
+	ColorSlot *int            `json:"color_slot,omitempty"`
+	Layers    []LayerManifest `json:"layers"`
+}
+
+// LayerManifest describes one layer of a set.
+type LayerManifest struct {
+	// Name identifies the layer, e.g. "eyes".
+	Name string `json:"name"`
+	// Dir is the directory holding the layer parts, relative to the set
+	// (or colour) directory.
+	Dir string `json:"dir"`
+	// Slot is the index of the hash part that selects the layer part.
+	Slot int `json:"slot"`
+	// Z orders the layers when composing, lowest first.
+	Z int `json:"z"`
+}
+
+func readManifest(fsys fs.FS, set string) (*SetManifest, error) {
+	data, err := fs.ReadFile(fsys, path.Join(set, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var m SetManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s manifest: %v", set, err)
+	}
+	if m.Name == "" {
+		m.Name = set
+	}
+	if m.Name != set {
+		return nil, fmt.Errorf("manifest name %q does not match directory %s", m.Name, set)
+	}
+	return &m, nil
+}
+
+// inferManifest describes a set that has no manifest.json from its
+// directory names, the way the bundled sets are laid out. Layer directories
+// get consecutive hash slots in name order; those carrying a z-order after
+// '#' are composited first, the rest follow in name order. It returns nil
+// if the directory does not look like a set.
+func inferManifest(fsys fs.FS, idx *assetIndex, set string) (*SetManifest, error) {
+	m := &SetManifest{Name: set}
+
+	layerBase := set
+	layerDirs := filterLayerDirs(idx.subdirs[set])
+	if len(layerDirs) == 0 {
+		colors := idx.subdirs[set]
+		if len(colors) == 0 {
+			return nil, nil
+		}
+		layerBase = path.Join(set, colors[0])
+		layerDirs = filterLayerDirs(idx.subdirs[layerBase])
+		if len(layerDirs) == 0 {
+			return nil, nil
+		}
+		colorSlot := 0
+		m.ColorSlot = &colorSlot
+	}
+
+	maxZ := -1
+	for _, dir := range layerDirs {
+		if z, _, ok := parseLayerDir(dir); ok && z > maxZ {
+			maxZ = z
+		}
+	}
+
+	unordered := 0
+	for i, dir := range layerDirs {
+		z, name, ok := parseLayerDir(dir)
+		if !ok {
+			unordered++
+			z = maxZ + unordered
+		}
+		m.Layers = append(m.Layers, LayerManifest{
+			Name: name,
+			Dir:  dir,
+			Slot: firstLayerSlot + i,
+			Z:    z,
+		})
+	}
+
+	files := idx.files[path.Join(layerBase, layerDirs[0])]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("set %s: no parts in %s", set, path.Join(layerBase, layerDirs[0]))
+	}
+	width, height, err := imageDimensions(fsys, files[0])
+	if err != nil {
+		return nil, fmt.Errorf("set %s: %v", set, err)
+	}
+	m.Width, m.Height = width, height
+
+	return m, nil
+}
+
+func filterLayerDirs(dirs []string) []string {
+	var layers []string
+	for _, dir := range dirs {
+		if layerDirPattern.MatchString(dir) {
+			layers = append(layers, dir)
+		}
+	}
+	return layers
+}
+
+// parseLayerDir splits a layer directory name into its z-order and layer
+// name. ok is false when the name carries no z-order.
+func parseLayerDir(dir string) (z int, name string, ok bool) {
+	match := layerDirPattern.FindStringSubmatch(dir)
+	name = strings.ToLower(match[2])
+	if match[1] == "" {
+		return 0, name, false
+	}
+	z, err := strconv.Atoi(match[1])
+	return z, name, err == nil
+}
+
+func imageDimensions(fsys fs.FS, name string) (int, int, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// layerDirs returns the directories holding the parts of every layer, one
+// group per colour variant.
+func (m *SetManifest) layerDirs(idx *assetIndex) [][]string {
+	bases := []string{m.Name}
+	if m.ColorSlot != nil {
+		bases = nil
+		for _, color := range idx.subdirs[m.Name] {
+			bases = append(bases, path.Join(m.Name, color))
+		}
+	}
+
+	groups := make([][]string, 0, len(bases))
+	for _, base := range bases {
+		dirs := make([]string, 0, len(m.Layers))
+		for _, layer := range m.Layers {
+			dirs = append(dirs, path.Join(base, layer.Dir))
+		}
+		groups = append(groups, dirs)
+	}
+	return groups
+}
+
+// validate checks the manifest against the indexed asset tree.
+func (m *SetManifest) validate(idx *assetIndex) error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("set %s: invalid dimensions %dx%d", m.Name, m.Width, m.Height)
+	}
+	if len(m.Layers) == 0 {
+		return fmt.Errorf("set %s: no layers", m.Name)
+	}
+	if m.ColorSlot != nil {
+		if *m.ColorSlot < 0 || *m.ColorSlot >= hashSlots {
+			return fmt.Errorf("set %s: color slot %d out of range", m.Name, *m.ColorSlot)
+		}
+		if len(idx.subdirs[m.Name]) == 0 {
+			return fmt.Errorf("set %s: no color directories", m.Name)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, layer := range m.Layers {
+		if layer.Name == "" || layer.Dir == "" {
+			return fmt.Errorf("set %s: layer needs a name and a directory", m.Name)
+		}
+		if names[layer.Name] {
+			return fmt.Errorf("set %s: duplicate layer %s", m.Name, layer.Name)
+		}
+		names[layer.Name] = true
+		if layer.Slot < 0 || layer.Slot >= hashSlots {
+			return fmt.Errorf("set %s: layer %s slot %d out of range", m.Name, layer.Name, layer.Slot)
+		}
+	}
+
+	for _, dirs := range m.layerDirs(idx) {
+		for _, dir := range dirs {
+			if idx.partCount(dir) == 0 {
+				return fmt.Errorf("set %s: no parts in %s", m.Name, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// order returns the layers in the order they are composited.
+func (m *SetManifest) order() []LayerManifest {
+	layers := append([]LayerManifest(nil), m.Layers...)
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].Z < layers[j].Z
+	})
+	return layers
+}
@@ -0,0 +1,221 @@
+package robohash
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/terem42/robohash/assets"
+)
+
+func TestBundledManifests(t *testing.T) {
+	idx, err := buildAssetIndex(assets.FS)
+	if err != nil {
+		t.Fatalf("buildAssetIndex() failed: %v", err)
+	}
+
+	// Directories in hash slot order (starting at slot 4) and composition
+	// order of the layers, as the sets were hard-coded before manifests.
+	expected := map[string]struct {
+		width, height int
+		colors        bool
+		dirs          []string
+		order         []string
+	}{
+		"set1": {300, 300, true,
+			[]string{"000#Mouth", "001#Eyes", "002#Accessory", "003#01Body", "004#02Face"},
+			[]string{"body", "face", "eyes", "mouth", "accessory"}},
+		"set2": {350, 350, false,
+			[]string{"000#04Body", "001#Mouth", "002#Eyes", "003#02BodyColors", "004#01FaceColors", "005#Nose", "006#03Faces"},
+			[]string{"facecolors", "bodycolors", "face", "body", "mouth", "eyes", "nose"}},
+		"set3": {1015, 1015, false,
+			[]string{"000#07Mouth", "001#02Wave", "002#05Eyebrows", "003#04Eyes", "004#06Nose", "005#01BaseFace", "006#03Antenna"},
+			[]string{"base", "wave", "antenna", "eyes", "eyebrows", "nose", "mouth"}},
+		"set4": {1024, 1024, false,
+			[]string{"000#00body", "001#01fur", "002#02eyes", "003#03mouth", "004#04accessories"},
+			[]string{"body", "fur", "eyes", "mouth", "accessory"}},
+		"set5": {1024, 1024, false,
+			[]string{"000#Body", "001#Eye", "002#Eyebrow", "003#Mouth", "004#Cloth", "005#FacialHair", "006#Top", "007#Accessories"},
+			[]string{"body", "eyes", "eyebrow", "mouth", "cloth", "facialhair", "top", "accessories"}},
+	}
+
+	if want := []string{"set1", "set2", "set3", "set4", "set5"}; !reflect.DeepEqual(idx.sets, want) {
+		t.Fatalf("sets = %v, want %v", idx.sets, want)
+	}
+
+	for set, want := range expected {
+		m := idx.manifests[set]
+		if m.Width != want.width || m.Height != want.height {
+			t.Errorf("%s: dimensions %dx%d, want %dx%d", set, m.Width, m.Height, want.width, want.height)
+		}
+		if (m.ColorSlot != nil) != want.colors || (m.ColorSlot != nil && *m.ColorSlot != 0) {
+			t.Errorf("%s: unexpected color slot %v", set, m.ColorSlot)
+		}
+
+		var dirs []string
+		for i, layer := range m.Layers {
+			if layer.Slot != 4+i {
+				t.Errorf("%s: layer %s uses slot %d, want %d", set, layer.Name, layer.Slot, 4+i)
+			}
+			dirs = append(dirs, layer.Dir)
+		}
+		if !reflect.DeepEqual(dirs, want.dirs) {
+			t.Errorf("%s: dirs = %v, want %v", set, dirs, want.dirs)
+		}
+
+		var order []string
+		for _, layer := range m.order() {
+			order = append(order, layer.Name)
+		}
+		if !reflect.DeepEqual(order, want.order) {
+			t.Errorf("%s: order = %v, want %v", set, order, want.order)
+		}
+	}
+}
+
+func TestManifestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		errText  string
+	}{
+		{"valid", `{"width": 10, "height": 10, "layers": [{"name": "a", "dir": "000#A", "slot": 4}]}`, ""},
+		{"name mismatch", `{"name": "other", "width": 10, "height": 10, "layers": [{"name": "a", "dir": "000#A", "slot": 4}]}`, "does not match"},
+		{"no dimensions", `{"layers": [{"name": "a", "dir": "000#A", "slot": 4}]}`, "invalid dimensions"},
+		{"no layers", `{"width": 10, "height": 10}`, "no layers"},
+		{"bad slot", `{"width": 10, "height": 10, "layers": [{"name": "a", "dir": "000#A", "slot": 22}]}`, "out of range"},
+		{"missing dir", `{"width": 10, "height": 10, "layers": [{"name": "a", "dir": "001#B", "slot": 4}]}`, "no parts"},
+		{"duplicate layer", `{"width": 10, "height": 10, "layers": [{"name": "a", "dir": "000#A", "slot": 4}, {"name": "a", "dir": "000#A", "slot": 5}]}`, "duplicate"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"setx/manifest.json": {Data: []byte(tc.manifest)},
+				"setx/000#A/1.png":   {},
+			}
+			idx, err := buildAssetIndex(fsys)
+			if err != nil {
+				t.Fatalf("buildAssetIndex() failed: %v", err)
+			}
+
+			m, err := readManifest(fsys, "setx")
+			if err == nil {
+				err = m.validate(idx)
+			}
+
+			if tc.errText == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if _, ok := idx.manifests["setx"]; !ok {
+					t.Error("valid set missing from index")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errText) {
+				t.Errorf("error = %v, want %q", err, tc.errText)
+			}
+			if _, ok := idx.manifests["setx"]; ok {
+				t.Error("invalid set present in index")
+			}
+		})
+	}
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestInferManifest(t *testing.T) {
+	part := testPNG(t, 64, 48)
+	fsys := fstest.MapFS{
+		"acme/000#Eyes/1.png":      {Data: part},
+		"acme/001#02Hat/1.png":     {Data: part},
+		"acme/002#01Head/1.png":    {Data: part},
+		"acme/002#01Head/2.png":    {Data: part},
+		"acme/003#Mouth/1.png":     {Data: part},
+		"acme/notes/readme.png":    {Data: part},
+		"tint/red/000#Body/1.png":  {Data: part},
+		"tint/red/001#Face/1.png":  {Data: part},
+		"tint/teal/000#Body/1.png": {Data: part},
+		"tint/teal/001#Face/1.png": {Data: part},
+		"broken/000#Body/1.png":    {Data: part},
+		"broken/001#Face/.keep":    {},
+		"docs/readme.txt":          {},
+	}
+
+	idx, err := buildAssetIndex(fsys)
+	if err != nil {
+		t.Fatalf("buildAssetIndex() failed: %v", err)
+	}
+
+	if want := []string{"acme", "tint"}; !reflect.DeepEqual(idx.sets, want) {
+		t.Fatalf("sets = %v, want %v", idx.sets, want)
+	}
+
+	acme := idx.manifests["acme"]
+	if acme.Width != 64 || acme.Height != 48 {
+		t.Errorf("acme dimensions = %dx%d, want 64x48", acme.Width, acme.Height)
+	}
+	if acme.ColorSlot != nil {
+		t.Error("acme should not have colour variants")
+	}
+	wantLayers := []LayerManifest{
+		{Name: "eyes", Dir: "000#Eyes", Slot: 4, Z: 3},
+		{Name: "hat", Dir: "001#02Hat", Slot: 5, Z: 2},
+		{Name: "head", Dir: "002#01Head", Slot: 6, Z: 1},
+		{Name: "mouth", Dir: "003#Mouth", Slot: 7, Z: 4},
+	}
+	if !reflect.DeepEqual(acme.Layers, wantLayers) {
+		t.Errorf("acme layers = %+v, want %+v", acme.Layers, wantLayers)
+	}
+
+	tint := idx.manifests["tint"]
+	if tint.ColorSlot == nil || *tint.ColorSlot != 0 {
+		t.Errorf("tint should have colour variants on slot 0, got %v", tint.ColorSlot)
+	}
+	if len(tint.Layers) != 2 {
+		t.Errorf("tint layers = %+v, want 2", tint.Layers)
+	}
+}
+
+func TestInferManifestMatchesBundledSets(t *testing.T) {
+	idx, err := buildAssetIndex(assets.FS)
+	if err != nil {
+		t.Fatalf("buildAssetIndex() failed: %v", err)
+	}
+
+	// set1 composes its unnumbered layers out of directory order, which is
+	// why it cannot do without a manifest.
+	for _, set := range []string{"set2", "set3", "set4", "set5"} {
+		inferred, err := inferManifest(assets.FS, idx, set)
+		if err != nil {
+			t.Fatalf("inferManifest(%s) failed: %v", set, err)
+		}
+
+		m := idx.manifests[set]
+		if inferred.Width != m.Width || inferred.Height != m.Height {
+			t.Errorf("%s: inferred %dx%d, manifest %dx%d", set, inferred.Width, inferred.Height, m.Width, m.Height)
+		}
+
+		var got, want []string
+		for _, layer := range inferred.order() {
+			got = append(got, layer.Dir)
+		}
+		for _, layer := range m.order() {
+			want = append(want, layer.Dir)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: inferred order %v, manifest order %v", set, got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package robohash
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// Recipe is the fully resolved description of an avatar: which files are
+// composited, in which order and at which size. It holds no pixels, so it
+// can be logged, compared or cached on its own and rendered later.
+type Recipe struct {
+	// Set is the set the avatar is drawn from, resolved if "any" was asked.
+	Set Set `json:"set"`
+	// Color is the colour variant directory for sets that have one.
+	Color string `json:"color,omitempty"`
+	// BGSet is the background set, resolved if "any" was asked.
+	BGSet BackgroundSet `json:"bgset,omitempty"`
+	// Background is the background file, empty for a transparent one.
+	Background string `json:"background,omitempty"`
+	// Layers lists the parts in composition order.
+	Layers []RecipeLayer `json:"layers"`
+	// Width and Height are the native set dimensions layers are composed at.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// TargetWidth and TargetHeight are the output dimensions, zero to keep
+	// the native ones.
+	TargetWidth  int `json:"target_width,omitempty"`
+	TargetHeight int `json:"target_height,omitempty"`
+	// Hashes holds the hash parts behind the choices not tied to a layer:
+	// "set" and "bgset" when "any" was resolved, "color" and "background".
+	Hashes map[string]string `json:"hashes,omitempty"`
+}
+
+// RecipeLayer is one part of a recipe.
+type RecipeLayer struct {
+	// Name is the layer name from the set manifest, e.g. "eyes".
+	Name string `json:"name"`
+	// File is the part file, empty when the layer directory has no parts.
+	File string `json:"file"`
+	// Index is the position of File among the parts of the layer.
+	Index int `json:"index"`
+	// Count is the number of parts the layer offers.
+	Count int `json:"count"`
+	// Hash is the hash part File was picked by.
+	Hash string `json:"hash"`
+}
+
+// Resolve works out the recipe for req without touching any pixels.
+func (g *Generator) Resolve(ctx context.Context, req Request) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	set := req.Set
+	if set == "" {
+		set = g.defaultSet
+	}
+	bgSet := req.BGSet
+
+	recipe := &Recipe{Hashes: make(map[string]string)}
+	if req.Size != "" {
+		width, height, err := g.ParseSize(req.Size)
+		if err != nil {
+			return nil, err
+		}
+		recipe.TargetWidth, recipe.TargetHeight = width, height
+	}
+
+	sha512 := sha512.New()
+	sha512.Write([]byte(req.Text))
+	hashBytes := sha512.Sum(nil)
+	hashString := hex.EncodeToString(hashBytes)
+
+	hashParts := splitHashIntoParts(hashString, 11)
+
+	idx, err := g.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if set == SetAny {
+		if len(idx.sets) == 0 {
+			return nil, fmt.Errorf("%w: no valid sets found", ErrAssetMissing)
+		}
+
+		setIndex := hexToInt(hashParts[1]) % len(idx.sets)
+		set = Set(idx.sets[setIndex])
+		recipe.Hashes["set"] = hashParts[1]
+	}
+
+	m, ok := idx.manifests[string(set)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSet, set)
+	}
+	recipe.Set = set
+	recipe.Width, recipe.Height = m.Width, m.Height
+
+	setDir := string(set)
+	if m.ColorSlot != nil {
+		colorDirs := idx.subdirs[string(set)]
+		colorIndex := hexToInt(hashParts[*m.ColorSlot]) % len(colorDirs)
+		recipe.Color = colorDirs[colorIndex]
+		recipe.Hashes["color"] = hashParts[*m.ColorSlot]
+		setDir = path.Join(setDir, recipe.Color)
+	}
+
+	for _, layer := range m.order() {
+		dir := path.Join(setDir, layer.Dir)
+		file, index, err := g.selectPart(idx, hashParts[layer.Slot], dir)
+		if err != nil {
+			return nil, err
+		}
+		recipe.Layers = append(recipe.Layers, RecipeLayer{
+			Name:  layer.Name,
+			File:  file,
+			Index: index,
+			Count: idx.partCount(dir),
+			Hash:  hashParts[layer.Slot],
+		})
+	}
+
+	bgSetHash := hashParts[3]
+	if bgSet == BackgroundAny {
+		if len(idx.backgrounds) == 0 {
+			return nil, fmt.Errorf("%w: no background sets found", ErrAssetMissing)
+		}
+		bgSetIndex := hexToInt(bgSetHash) % len(idx.backgrounds)
+		bgSet = BackgroundSet(idx.backgrounds[bgSetIndex])
+		recipe.Hashes["bgset"] = bgSetHash
+	}
+
+	if bgSet != BackgroundNone {
+		if !idx.hasBackground(string(bgSet)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownBackground, bgSet)
+		}
+		recipe.BGSet = bgSet
+		bgFiles := idx.files[path.Join("backgrounds", string(bgSet))]
+		if len(bgFiles) == 0 {
+			if g.strict {
+				return nil, fmt.Errorf("%w: no background files found for %s", ErrAssetMissing, bgSet)
+			}
+			g.logger.Printf("No background files found for %s", bgSet)
+		} else {
+			bgIndex := hexToInt(hashString[0:12]) % len(bgFiles)
+			recipe.Background = bgFiles[bgIndex]
+			recipe.Hashes["background"] = hashString[0:12]
+		}
+	}
+
+	return recipe, nil
+}
+
+// missingBackground reports a recipe asking for a background set that has
+// no files, so the avatar is drawn without its background. A strict
+// Generator fails instead.
+func (g *Generator) missingBackground(recipe *Recipe) ([]SkippedLayer, error) {
+	if recipe.BGSet == BackgroundNone || recipe.Background != "" {
+		return nil, nil
+	}
+	err := fmt.Errorf("%w: no background files found for %s", ErrAssetMissing, recipe.BGSet)
+	if g.strict {
+		return nil, err
+	}
+	return []SkippedLayer{{Name: "background", Err: err}}, nil
+}
+
+// Render composites the files named by recipe. It stops with ctx.Err()
+// once ctx is done. Unless the Generator is strict, layers that cannot be
+// rendered are left out and listed in Avatar.Skipped.
+func (g *Generator) Render(ctx context.Context, recipe *Recipe) (*Avatar, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img, skipped, err := g.composeImage(ctx, recipe)
+	if err != nil {
+		return nil, err
+	}
+	return &Avatar{Image: img, Recipe: recipe, Skipped: skipped}, nil
+}
@@ -0,0 +1,166 @@
+package robohash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	recipe, err := g.Resolve(context.Background(), Request{Text: "alice", Set: "set1", Size: "150x150", BGSet: "bg1"})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	if recipe.Set != "set1" || recipe.Color == "" {
+		t.Errorf("Unexpected set/color: %s/%s", recipe.Set, recipe.Color)
+	}
+	if recipe.Width != 300 || recipe.Height != 300 || recipe.TargetWidth != 150 || recipe.TargetHeight != 150 {
+		t.Errorf("Unexpected dimensions: %+v", recipe)
+	}
+	if !strings.HasPrefix(recipe.Background, "backgrounds/bg1/") {
+		t.Errorf("Unexpected background: %s", recipe.Background)
+	}
+	if recipe.Hashes["color"] == "" || recipe.Hashes["background"] == "" {
+		t.Errorf("Missing hashes: %v", recipe.Hashes)
+	}
+	if _, ok := recipe.Hashes["set"]; ok {
+		t.Error("Set hash reported although the set was not resolved from any")
+	}
+
+	var names []string
+	for _, layer := range recipe.Layers {
+		names = append(names, layer.Name)
+		if dir := path.Join("set1", recipe.Color); !strings.HasPrefix(layer.File, dir+"/") {
+			t.Errorf("Layer %s file %s is outside %s", layer.Name, layer.File, dir)
+		}
+		if layer.Count == 0 || layer.Index != hexToInt(layer.Hash)%layer.Count {
+			t.Errorf("Layer %s index %d does not follow from hash %s and %d parts",
+				layer.Name, layer.Index, layer.Hash, layer.Count)
+		}
+	}
+	if want := []string{"body", "face", "eyes", "mouth", "accessory"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Layer order = %v, want %v", names, want)
+	}
+
+	again, err := g.Resolve(context.Background(), Request{Text: "alice", Set: "set1", Size: "150x150", BGSet: "bg1"})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+	if !reflect.DeepEqual(recipe, again) {
+		t.Error("Resolve() is not deterministic")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	g, err := NewGenerator(WithMaxSize(500, 500))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	tests := []struct {
+		req  Request
+		want error
+	}{
+		{Request{Set: "set9"}, ErrUnknownSet},
+		{Request{BGSet: "bg9"}, ErrUnknownBackground},
+		{Request{Size: "abc"}, ErrInvalidSize},
+		{Request{Size: "600x600"}, ErrInvalidSize},
+	}
+	for _, tc := range tests {
+		if _, err := g.Resolve(context.Background(), tc.req); !errors.Is(err, tc.want) {
+			t.Errorf("Resolve(%+v) error = %v, want %v", tc.req, err, tc.want)
+		}
+	}
+}
+
+func TestRenderRecipeMatchesGenerate(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	req := Request{Text: "recipe_render", Set: "set2", BGSet: "any"}
+	generated, err := g.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	defer generated.Close()
+
+	// A recipe survives a JSON round trip, so it can be stored and rendered
+	// later.
+	data, err := json.Marshal(generated.Recipe)
+	if err != nil {
+		t.Fatalf("Failed to marshal recipe: %v", err)
+	}
+	var recipe Recipe
+	if err := json.Unmarshal(data, &recipe); err != nil {
+		t.Fatalf("Failed to unmarshal recipe: %v", err)
+	}
+
+	rendered, err := g.Render(context.Background(), &recipe)
+	if err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+	defer rendered.Close()
+
+	png1, _, err := generated.Image.ExportPng(nil)
+	if err != nil {
+		t.Fatalf("Failed to export generated image: %v", err)
+	}
+	png2, _, err := rendered.Image.ExportPng(nil)
+	if err != nil {
+		t.Fatalf("Failed to export rendered image: %v", err)
+	}
+	if md5Hash(png1) != md5Hash(png2) {
+		t.Error("Rendered recipe differs from generated avatar")
+	}
+}
+
+// countdownContext reports cancellation once Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestRenderStopsBetweenLayers(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	recipe, err := g.Resolve(context.Background(), Request{Text: "cancel_layers", Set: "set5"})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	// Render itself and the first two layers see a live context.
+	ctx := &countdownContext{Context: context.Background(), n: 3}
+	if _, err := g.Render(ctx, recipe); !errors.Is(err, context.Canceled) {
+		t.Errorf("Render() error = %v, want context.Canceled", err)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := g.Render(expired, recipe); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Render() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -1,11 +1,14 @@
 package robohash
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"strconv"
 	"testing"
+	"testing/fstest"
 
 	"github.com/davidbyttow/govips/v2/vips"
 )
@@ -13,16 +16,15 @@ import (
 type testCase struct {
 	name          string
 	text          string
-	set           string
+	set           Set
 	size          string
-	bgSet         string
+	bgSet         BackgroundSet
 	png_expected  string
 	avif_expected string
 	webp_expected string
 }
 
 func setupTests() {
-	assetsDir = "../assets"
 	vips.Startup(&vips.Config{
 		ConcurrencyLevel: 0,
 		MaxCacheFiles:    100,
@@ -154,7 +156,7 @@ func TestDifferentSizes(t *testing.T) {
 
 func TestConsistencyPNG(t *testing.T) {
 	text := "consistency_test"
-	set := "set1"
+	set := Set1
 
 	robo1 := NewRoboHash(text, set)
 	img1, err := robo1.Generate()
@@ -190,7 +192,7 @@ func TestConsistencyPNG(t *testing.T) {
 
 func TestConsistencyWEBP(t *testing.T) {
 	text := "consistency_test"
-	set := "set1"
+	set := Set1
 
 	robo1 := NewRoboHash(text, set)
 	img1, err := robo1.Generate()
@@ -224,13 +226,51 @@ func TestConsistencyWEBP(t *testing.T) {
 	}
 }
 
+func TestConsistencyEncode(t *testing.T) {
+	text := "consistency_test"
+	set := Set1
+
+	for _, format := range []Format{FormatGIF, FormatTIFF, FormatHEIF, FormatJXL} {
+		t.Run(string(format), func(t *testing.T) {
+			var hashes []string
+			for i := 0; i < 2; i++ {
+				img, err := NewRoboHash(text, set).Generate()
+				if err != nil {
+					t.Fatalf("Generation %d failed: %v", i+1, err)
+				}
+
+				var buf bytes.Buffer
+				err = Encode(img, format, DefaultEncodeOptions(format), &buf)
+				img.Close()
+				if err != nil {
+					t.Fatalf("Failed to export image %d: %v", i+1, err)
+				}
+				hashes = append(hashes, md5Hash(buf.Bytes()))
+			}
+
+			if hashes[0] != hashes[1] {
+				t.Errorf("Images are not consistent: hash1=%s, hash2=%s", hashes[0], hashes[1])
+			}
+		})
+	}
+}
+
 func TestAllSets(t *testing.T) {
-	sets := []string{"set1", "set2", "set3", "set4", "set5"}
+	sets := []struct {
+		set           Set
+		width, height int
+	}{
+		{Set1, 300, 300},
+		{Set2, 350, 350},
+		{Set3, 1015, 1015},
+		{Set4, 1024, 1024},
+		{Set5, 1024, 1024},
+	}
 
-	for _, set := range sets {
-		t.Run("Set_"+set, func(t *testing.T) {
-			expectedWidth, expectedHeight := getSetDimensions(set)
-			robo := NewRoboHash("test_"+set, set)
+	for _, tc := range sets {
+		set, expectedWidth, expectedHeight := tc.set, tc.width, tc.height
+		t.Run("Set_"+string(set), func(t *testing.T) {
+			robo := NewRoboHash("test_"+string(set), set)
 			robo.Size = strconv.Itoa(expectedWidth) + "x" + strconv.Itoa(expectedHeight)
 			img, err := robo.Generate()
 			if err != nil {
@@ -251,6 +291,67 @@ func TestAllSets(t *testing.T) {
 	}
 }
 
+func TestAssetsDirMatchesEmbedded(t *testing.T) {
+	text := "assets_dir_test"
+
+	img1, err := NewRoboHash(text, "set2").Generate()
+	if err != nil {
+		t.Fatalf("Generate() from embedded assets failed: %v", err)
+	}
+	defer img1.Close()
+
+	g, err := NewGenerator(WithAssetsDir("../assets"))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	avatar, err := g.Generate(context.Background(), Request{Text: text, Set: "set2", Size: "300x300"})
+	if err != nil {
+		t.Fatalf("Generate() from assets directory failed: %v", err)
+	}
+	defer avatar.Close()
+	img2 := avatar.Image
+
+	png1, _, err := img1.ExportPng(&vips.PngExportParams{Quality: 100})
+	if err != nil {
+		t.Fatalf("Failed to export first image: %v", err)
+	}
+
+	png2, _, err := img2.ExportPng(&vips.PngExportParams{Quality: 100})
+	if err != nil {
+		t.Fatalf("Failed to export second image: %v", err)
+	}
+
+	if md5Hash(png1) != md5Hash(png2) {
+		t.Error("Images rendered from embedded and on-disk assets differ")
+	}
+}
+
+func TestCustomSet(t *testing.T) {
+	part := testPNG(t, 64, 48)
+	g, err := NewGenerator(WithAssets(fstest.MapFS{
+		"acme/000#01Head/1.png": {Data: part},
+		"acme/001#Eyes/1.png":   {Data: part},
+		"acme/001#Eyes/2.png":   {Data: part},
+	}))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	for _, set := range []Set{"acme", SetAny} {
+		avatar, err := g.Generate(context.Background(), Request{Text: "custom_set", Set: set})
+		if err != nil {
+			t.Fatalf("Generate() with set %s failed: %v", set, err)
+		}
+		if avatar.Recipe.Set != "acme" {
+			t.Errorf("Resolved set = %s, want acme", avatar.Recipe.Set)
+		}
+		if avatar.Image.Width() != 64 || avatar.Image.Height() != 48 {
+			t.Errorf("Wrong dimensions for set %s: %dx%d", set, avatar.Image.Width(), avatar.Image.Height())
+		}
+		avatar.Close()
+	}
+}
+
 // Benchmark tests
 func BenchmarkGenerate(b *testing.B) {
 	robo := NewRoboHash("benchmark_test", "set1")
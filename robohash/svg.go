@@ -0,0 +1,79 @@
+package robohash
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// EncodeSVG writes recipe as an SVG document with one <image> element per
+// layer, background first and then the layers in composition order. The
+// part files are embedded unchanged as PNG data URIs and scaled through the
+// viewBox, so the document does not depend on the target size. Each image
+// has the id of its layer name ("background" for the background) and the
+// class "robohash-layer".
+//
+// Unless the Generator is strict, layers whose part cannot be read are left
+// out and returned.
+func (g *Generator) EncodeSVG(ctx context.Context, recipe *Recipe, w io.Writer) ([]SkippedLayer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d">`+"\n", recipe.Width, recipe.Height)
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(string(recipe.Set)))
+
+	skipped, err := g.missingBackground(recipe)
+	if err != nil {
+		return nil, err
+	}
+	if recipe.Background != "" {
+		if err := g.writeSVGImage(&b, "background", recipe.Background, recipe); err != nil {
+			return nil, fmt.Errorf("error loading background: %w", err)
+		}
+	}
+
+	for _, layer := range recipe.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if layer.File == "" {
+			err = fmt.Errorf("%w: no part for layer %s", ErrAssetMissing, layer.Name)
+		} else if err = g.writeSVGImage(&b, layer.Name, layer.File, recipe); err != nil {
+			err = fmt.Errorf("error loading part %s (%s): %w", layer.Name, layer.File, err)
+		}
+		if err == nil {
+			continue
+		}
+		if g.strict {
+			return nil, err
+		}
+		g.logger.Printf("Skipping part: %v", err)
+		skipped = append(skipped, SkippedLayer{Name: layer.Name, File: layer.File, Err: err})
+	}
+	b.WriteString("</svg>\n")
+
+	_, err = io.WriteString(w, b.String())
+	return skipped, err
+}
+
+// writeSVGImage embeds one part file as an <image> element.
+func (g *Generator) writeSVGImage(b *strings.Builder, id, name string, recipe *Recipe) error {
+	data, err := fs.ReadFile(g.assets, name)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrAssetMissing, name, err)
+	}
+
+	fmt.Fprintf(b, `<image id="%s" class="robohash-layer" x="0" y="0" width="%d" height="%d" preserveAspectRatio="none" href="data:image/png;base64,`,
+		html.EscapeString(id), recipe.Width, recipe.Height)
+	b.WriteString(base64.StdEncoding.EncodeToString(data))
+	b.WriteString("\"/>\n")
+	return nil
+}
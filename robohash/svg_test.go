@@ -0,0 +1,100 @@
+package robohash
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEncodeSVG(t *testing.T) {
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	recipe, err := g.Resolve(context.Background(), Request{Text: "svg", Set: Set1, Size: "64x64", BGSet: Background1})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	skipped, err := g.EncodeSVG(context.Background(), recipe, &buf)
+	if err != nil || len(skipped) > 0 {
+		t.Fatalf("EncodeSVG() = %v, %v", skipped, err)
+	}
+
+	var doc struct {
+		ViewBox string `xml:"viewBox,attr"`
+		Images  []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"image"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("EncodeSVG() wrote invalid XML: %v", err)
+	}
+
+	if doc.ViewBox != "0 0 300 300" {
+		t.Errorf("viewBox = %q, want the native set size", doc.ViewBox)
+	}
+	want := []string{"background"}
+	for _, layer := range recipe.Layers {
+		want = append(want, layer.Name)
+	}
+	if len(doc.Images) != len(want) {
+		t.Fatalf("SVG has %d images, want %d", len(doc.Images), len(want))
+	}
+	for i, img := range doc.Images {
+		if img.ID != want[i] {
+			t.Errorf("image %d has id %q, want %q", i, img.ID, want[i])
+		}
+		if !bytes.HasPrefix([]byte(img.Href), []byte("data:image/png;base64,")) {
+			t.Errorf("image %s is not an embedded PNG", img.ID)
+		}
+	}
+}
+
+func TestEncodeSVGMissingPart(t *testing.T) {
+	fsys := fstest.MapFS{"acme/000#01Head/1.png": {Data: testPNG(t, 4, 4)}}
+	recipe := &Recipe{
+		Set:    "acme",
+		Width:  4,
+		Height: 4,
+		Layers: []RecipeLayer{{Name: "head", File: "acme/000#01Head/9.png"}},
+	}
+
+	for _, strict := range []bool{false, true} {
+		g, err := NewGenerator(WithAssets(fsys), WithStrict(strict))
+		if err != nil {
+			t.Fatalf("NewGenerator() failed: %v", err)
+		}
+		skipped, err := g.EncodeSVG(context.Background(), recipe, &bytes.Buffer{})
+		if strict && !errors.Is(err, ErrAssetMissing) {
+			t.Errorf("strict EncodeSVG() = %v, want ErrAssetMissing", err)
+		}
+		if !strict && (err != nil || len(skipped) != 1) {
+			t.Errorf("EncodeSVG() = %v, %v, want the head skipped", skipped, err)
+		}
+	}
+}
+
+func TestEncodeSVGEmptyBackground(t *testing.T) {
+	g, err := NewGenerator(WithAssets(fstest.MapFS{
+		"acme/000#01Head/1.png":   {Data: testPNG(t, 4, 4)},
+		"backgrounds/empty/.keep": {},
+	}))
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+	recipe, err := g.Resolve(context.Background(), Request{Text: "svg", Set: "acme", BGSet: "empty"})
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	skipped, err := g.EncodeSVG(context.Background(), recipe, &bytes.Buffer{})
+	if err != nil || len(skipped) != 1 || skipped[0].Name != "background" || !errors.Is(skipped[0].Err, ErrAssetMissing) {
+		t.Errorf("EncodeSVG() = %v, %v, want the background skipped", skipped, err)
+	}
+}
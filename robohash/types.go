@@ -0,0 +1,192 @@
+package robohash
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Set names an avatar set: one of the bundled sets below or a custom set
+// found in the asset source.
+type Set string
+
+const (
+	Set1   Set = "set1" // Robots
+	Set2   Set = "set2" // Monsters
+	Set3   Set = "set3" // Robot heads
+	Set4   Set = "set4" // Cats
+	Set5   Set = "set5" // Human avatars
+	SetAny Set = "any"  // Picked from all available sets by the hash
+)
+
+// BackgroundSet names a directory of backgrounds.
+type BackgroundSet string
+
+const (
+	BackgroundNone BackgroundSet = ""
+	Background1    BackgroundSet = "bg1"
+	Background2    BackgroundSet = "bg2"
+	BackgroundAny  BackgroundSet = "any" // Picked from all background sets by the hash
+)
+
+// Format is an output image format.
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatJPEG Format = "jpeg"
+	FormatWebP Format = "webp"
+	FormatAVIF Format = "avif"
+	FormatGIF  Format = "gif"
+	FormatTIFF Format = "tiff"
+	FormatHEIF Format = "heic"
+	FormatJXL  Format = "jxl"
+	FormatICO  Format = "ico" // PNG images of IconSizes in one icon file
+	FormatSVG  Format = "svg" // Written from a recipe by Generator.EncodeSVG
+)
+
+// Formats lists the supported output formats.
+var Formats = []Format{FormatPNG, FormatJPEG, FormatWebP, FormatAVIF, FormatGIF, FormatTIFF, FormatHEIF, FormatJXL, FormatICO, FormatSVG}
+
+// formatAliases are the other names and extensions of some formats.
+var formatAliases = map[string]Format{
+	"jpg":  FormatJPEG,
+	"tif":  FormatTIFF,
+	"heif": FormatHEIF,
+}
+
+// ParseFormat validates a format name such as "png", "JPG" or "tif".
+func ParseFormat(s string) (Format, error) {
+	name := strings.ToLower(strings.TrimPrefix(s, "."))
+	if f, ok := formatAliases[name]; ok {
+		return f, nil
+	}
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownFormat, s)
+}
+
+// ParseSize validates a "{width}x{height}" size against DefaultMaxSize.
+func ParseSize(s string) (width, height int, err error) {
+	return parseSizeWithin(s, DefaultMaxSize, DefaultMaxSize)
+}
+
+func parseSizeWithin(s string, maxWidth, maxHeight int) (width, height int, err error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w %q, want {width}x{height}", ErrInvalidSize, s)
+	}
+
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("%w %q, want {width}x{height}", ErrInvalidSize, s)
+	}
+	if width > maxWidth || height > maxHeight {
+		return 0, 0, fmt.Errorf("%w: %dx%d exceeds the %dx%d limit", ErrInvalidSize, width, height, maxWidth, maxHeight)
+	}
+	return width, height, nil
+}
+
+// ParseSize validates a "{width}x{height}" size against the generator
+// limits.
+func (g *Generator) ParseSize(s string) (width, height int, err error) {
+	return parseSizeWithin(s, g.maxWidth, g.maxHeight)
+}
+
+// ParseSet validates a set name against the asset source. An empty name
+// selects the default set.
+func (g *Generator) ParseSet(s string) (Set, error) {
+	if s == "" {
+		return g.defaultSet, nil
+	}
+	if Set(s) == SetAny {
+		return SetAny, nil
+	}
+
+	idx, err := g.loadIndex()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := idx.manifests[s]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSet, s)
+	}
+	return Set(s), nil
+}
+
+// ParseBackgroundSet validates a background set name against the asset
+// source. An empty name means no background.
+func (g *Generator) ParseBackgroundSet(s string) (BackgroundSet, error) {
+	if s == "" || BackgroundSet(s) == BackgroundAny {
+		return BackgroundSet(s), nil
+	}
+
+	idx, err := g.loadIndex()
+	if err != nil {
+		return "", err
+	}
+	if !idx.hasBackground(s) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownBackground, s)
+	}
+	return BackgroundSet(s), nil
+}
+
+// AvailableSets lists the sets found in the asset source, in the order
+// SetAny picks from.
+func (g *Generator) AvailableSets() []Set {
+	idx, err := g.loadIndex()
+	if err != nil {
+		return nil
+	}
+
+	sets := make([]Set, 0, len(idx.sets))
+	for _, name := range idx.sets {
+		sets = append(sets, Set(name))
+	}
+	return sets
+}
+
+// AvailableBackgrounds lists the background sets found in the asset source.
+func (g *Generator) AvailableBackgrounds() []BackgroundSet {
+	idx, err := g.loadIndex()
+	if err != nil {
+		return nil
+	}
+
+	backgrounds := make([]BackgroundSet, 0, len(idx.backgrounds))
+	for _, name := range idx.backgrounds {
+		backgrounds = append(backgrounds, BackgroundSet(name))
+	}
+	return backgrounds
+}
+
+// ParseSet validates a set name against the default asset source.
+func ParseSet(s string) (Set, error) {
+	g, err := defaultGenerator()
+	if err != nil {
+		return "", err
+	}
+	return g.ParseSet(s)
+}
+
+// ParseBackgroundSet validates a background set name against the default
+// asset source.
+func ParseBackgroundSet(s string) (BackgroundSet, error) {
+	g, err := defaultGenerator()
+	if err != nil {
+		return "", err
+	}
+	return g.ParseBackgroundSet(s)
+}
+
+// AvailableSets lists the sets of the default asset source.
+func AvailableSets() []Set {
+	g, err := defaultGenerator()
+	if err != nil {
+		return nil
+	}
+	return g.AvailableSets()
+}
@@ -0,0 +1,94 @@
+package robohash
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{
+		"png":   FormatPNG,
+		".PNG":  FormatPNG,
+		"jpg":   FormatJPEG,
+		"jpeg":  FormatJPEG,
+		".webp": FormatWebP,
+		"avif":  FormatAVIF,
+		"gif":   FormatGIF,
+		".TIF":  FormatTIFF,
+		"tiff":  FormatTIFF,
+		"heic":  FormatHEIF,
+		"heif":  FormatHEIF,
+		".jxl":  FormatJXL,
+		"ico":   FormatICO,
+		"SVG":   FormatSVG,
+	} {
+		got, err := ParseFormat(input)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "bmp", "png.jpg"} {
+		if _, err := ParseFormat(input); err == nil {
+			t.Errorf("ParseFormat(%q) accepted an unknown format", input)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	width, height, err := ParseSize("300x200")
+	if err != nil || width != 300 || height != 200 {
+		t.Errorf("ParseSize(300x200) = %d, %d, %v", width, height, err)
+	}
+
+	for _, input := range []string{"", "abc", "300", "300x", "x300", "0x300", "-1x300", "300x300x300", "5000x5000"} {
+		if _, _, err := ParseSize(input); err == nil {
+			t.Errorf("ParseSize(%q) accepted an invalid size", input)
+		}
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	part := testPNG(t, 32, 32)
+	g, err := NewGenerator(
+		WithDefaultSet("acme"),
+		WithMaxSize(100, 100),
+		WithAssets(fstest.MapFS{
+			"acme/000#Head/1.png":     {Data: part},
+			"backgrounds/night/1.png": {Data: part},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGenerator() failed: %v", err)
+	}
+
+	if sets := g.AvailableSets(); !reflect.DeepEqual(sets, []Set{"acme"}) {
+		t.Errorf("AvailableSets() = %v, want [acme]", sets)
+	}
+	if backgrounds := g.AvailableBackgrounds(); !reflect.DeepEqual(backgrounds, []BackgroundSet{"night"}) {
+		t.Errorf("AvailableBackgrounds() = %v, want [night]", backgrounds)
+	}
+
+	for input, want := range map[string]Set{"": "acme", "acme": "acme", "any": SetAny} {
+		if got, err := g.ParseSet(input); err != nil || got != want {
+			t.Errorf("ParseSet(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := g.ParseSet("set1"); err == nil {
+		t.Error("ParseSet() accepted a set missing from the asset source")
+	}
+
+	for _, input := range []string{"", "any", "night"} {
+		if _, err := g.ParseBackgroundSet(input); err != nil {
+			t.Errorf("ParseBackgroundSet(%q) failed: %v", input, err)
+		}
+	}
+	if _, err := g.ParseBackgroundSet("bg1"); err == nil {
+		t.Error("ParseBackgroundSet() accepted a background set missing from the asset source")
+	}
+
+	if _, _, err := g.ParseSize("150x150"); err == nil {
+		t.Error("ParseSize() ignored the generator size limit")
+	}
+}